@@ -21,6 +21,10 @@ env GOPATH=`pwd` GOOS=windows GOARCH=386 go build -o windows-chat-server-32.exe
 echo "Compiling Windows 64-bit"
 env GOPATH=`pwd` GOOS=windows GOARCH=amd64 go build -o windows-chat-server.exe sibte.so
 
+echo "Compiling load generator for Linux ARM6 and 64-bit"
+env GOPATH=`pwd` GOOS=linux GOARCH=arm GOARM=6 go build -o arm-rasload sibte.so/rasload
+env GOPATH=`pwd` GOOS=linux GOARCH=amd64 go build -o rasload sibte.so/rasload
+
 mv arm-server ./dist
 mv chat-server-32 ./dist
 mv chat-server ./dist
@@ -28,3 +32,5 @@ mv macos-chat-server-32 ./dist
 mv macos-chat-server ./dist
 mv windows-chat-server-32.exe ./dist
 mv windows-chat-server.exe ./dist
+mv arm-rasload ./dist
+mv rasload ./dist
@@ -11,6 +11,9 @@ go get github.com/boltdb/bolt/...
 go get gopkg.in/natefinch/lumberjack.v2
 go get github.com/googollee/go-gcm
 go get github.com/Azure/azure-sdk-for-go/management
+go get gopkg.in/yaml.v2
+go get github.com/BurntSushi/toml
+go get github.com/gomodule/redigo/redis
 
 
 pushd src/github.com/speps/go-hashids
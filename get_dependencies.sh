@@ -14,6 +14,9 @@ env GOPATH=`pwd` go get github.com/Azure/azure-sdk-for-go/management
 env GOPATH=`pwd` go get github.com/urfave/negroni
 env GOPATH=`pwd` go get github.com/Workiva/go-datastructures/...
 env GOPATH=`pwd` go get github.com/syndtr/goleveldb/leveldb
+env GOPATH=`pwd` go get gopkg.in/yaml.v2
+env GOPATH=`pwd` go get github.com/BurntSushi/toml
+env GOPATH=`pwd` go get github.com/gomodule/redigo/redis
 
 pushd src/github.com/speps/go-hashids
 git checkout -q master
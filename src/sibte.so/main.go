@@ -0,0 +1,410 @@
+package main
+
+/*
+Copyright (c) 2015 Zohaib
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+    "context"
+    "crypto/subtle"
+    "crypto/tls"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "os/signal"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "github.com/julienschmidt/httprouter"
+    "gopkg.in/natefinch/lumberjack.v2"
+
+    "sibte.so/rasconfig"
+    "sibte.so/rasmetrics"
+    "sibte.so/rasrate"
+    "sibte.so/rasrestart"
+    "sibte.so/rastls"
+    "sibte.so/rasweb"
+    "sibte.so/rica"
+    "sibte.so/rica/consts"
+)
+
+func installSocketMux(mux *http.ServeMux, appConfig rasconfig.ApplicationConfig) (s *rica.ChatService, err error) {
+    err = nil
+    s = rica.NewChatService(appConfig)
+    if !appConfig.DisableGifs {
+        s.AddFeatures(ricaEvents.FEATURE_GIFS)
+    }
+    handler := s.WithRESTRoutes("/chat")
+
+    mux.Handle("/chat", handler)
+    mux.Handle("/chat/", handler)
+    return
+}
+
+var routeHandlers = []rasweb.RouteHandler{
+    rasweb.NewFileUploadHandler(),
+    rasweb.NewConfigRouteHandler(),
+    rasweb.NewDirectPagesHandler(),
+}
+
+func installHTTPRoutes(mux *http.ServeMux, appConfig rasconfig.ApplicationConfig) (err error) {
+    err = nil
+    router := httprouter.New()
+
+    if !appConfig.DisableGifs {
+        routeHandlers = append(routeHandlers, rasweb.NewGifHandler())
+    }
+
+    // Register all routes
+    for _, h := range routeHandlers {
+        if err := h.Register(router); err != nil {
+            log.Panic("Unable to register route")
+        }
+    }
+
+    router.ServeFiles("/static/*filepath", http.Dir("./static"))
+    mux.Handle("/", router)
+    return
+}
+
+const cDefaultShutdownTimeout = 10 * time.Second
+
+// Set once the listeners were handed to a new process and closed here
+var handedOff atomic.Bool
+
+func installHealthRoutes(mux *http.ServeMux, chatService *rica.ChatService) {
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "ok")
+    })
+
+    mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+        if !chatService.Ready() {
+            http.Error(w, "shutting down", http.StatusServiceUnavailable)
+            return
+        }
+
+        fmt.Fprintf(w, "ok")
+    })
+}
+
+// installAdminRoutes exposes config reload and metrics to requests carrying
+// the configured secret as a bearer token, without a secret they stay disabled
+func installAdminRoutes(mux *http.ServeMux) {
+    mux.HandleFunc("/admin/reload", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.WriteHeader(http.StatusMethodNotAllowed)
+            return
+        }
+
+        if !isAdmin(r) {
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+
+        if _, err := rasconfig.Reload(); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        fmt.Fprintf(w, "ok")
+    })
+
+    metrics := rasmetrics.Handler()
+    mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+        if !isAdmin(r) {
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+
+        metrics.ServeHTTP(w, r)
+    })
+}
+
+func isAdmin(r *http.Request) bool {
+    secret := rasconfig.Current().AppSecretKey
+    return secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+secret)) == 1
+}
+
+// shutdown disconnects chat clients first so their streaming requests end,
+// then stops the HTTP servers and closes route handler stores
+func shutdown(servers []*http.Server, chatService *rica.ChatService, timeout time.Duration) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    if err := chatService.Shutdown(ctx); err != nil {
+        log.Println("Chat service shutdown", err)
+    }
+
+    shutdownServers(ctx, servers)
+    closeRouteHandlers()
+}
+
+func shutdownServers(ctx context.Context, servers []*http.Server) {
+    for _, server := range servers {
+        if err := server.Shutdown(ctx); err != nil && !errors.Is(err, net.ErrClosed) {
+            log.Println("HTTP server shutdown", err)
+        }
+    }
+}
+
+func closeRouteHandlers() {
+    for _, h := range routeHandlers {
+        if closer, ok := h.(io.Closer); ok {
+            closer.Close()
+        }
+    }
+}
+
+// loadTLSConfig returns nil without a certificate, certificates are watched
+// and reloaded for as long as the process runs
+func loadTLSConfig(conf *rasconfig.ApplicationConfig, certFile, keyFile string) (*tls.Config, error) {
+    if certFile == "" && keyFile == "" {
+        return nil, nil
+    }
+
+    config, _, err := rastls.NewServerConfig(rastls.Options{
+        CertFile:          certFile,
+        KeyFile:           keyFile,
+        MinVersion:        conf.TLSMinVersion,
+        ClientCAFile:      conf.TLSClientCAFile,
+        RequireClientCert: conf.TLSRequireClientCert,
+    })
+
+    return config, err
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the TLS port
+func redirectToHTTPS(tlsAddr string) http.Handler {
+    _, port, _ := net.SplitHostPort(tlsAddr)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host := strings.Trim(rasrate.HostOf(r.Host), "[]")
+        if port != "" && port != "443" {
+            host = net.JoinHostPort(host, port)
+        } else if strings.Contains(host, ":") {
+            host = "[" + host + "]"
+        }
+
+        http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+    })
+}
+
+// hotRestart hands the listening sockets to a new copy of the process and
+// drains this one. Connections queue on the shared sockets until the child
+// got the stores, which this process closes once it stopped accepting and
+// saved every client's resume token. False if the child couldn't be started
+func hotRestart(servers []*http.Server, listeners map[string]net.Listener, chatService *rica.ChatService, timeout time.Duration, handoff *rasrestart.Handoff) bool {
+    defer handoff.Close()
+
+    process, err := handoff.Start()
+    if err != nil {
+        log.Println("Unable to start new process", err)
+        return false
+    }
+    log.Println("Handed off listeners to process", process.Pid)
+
+    // Closing the listeners stops accepting without touching connections
+    // already accepted, server.Shutdown would drop requests arriving on them
+    handedOff.Store(true)
+    for _, l := range listeners {
+        l.Close()
+    }
+
+    for _, server := range servers {
+        server.SetKeepAlivesEnabled(false)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    err = chatService.ShutdownForRestart(ctx, func() {
+        closeRouteHandlers()
+        handoff.Release()
+        log.Println("Released stores to process", process.Pid)
+    })
+    if err != nil {
+        log.Println("Chat service shutdown", err)
+    }
+
+    shutdownServers(ctx, servers)
+    return true
+}
+
+func parseArgs() (filePath string, checkOnly bool) {
+    flag.StringVar(&filePath, "config", "", "Path to configuration file (.json, .yaml or .toml)")
+    flag.BoolVar(&checkOnly, "check-config", false, "Validate the configuration and exit")
+    flag.Parse()
+    return
+}
+
+func main() {
+    filePath, checkOnly := parseArgs()
+    if err := rasconfig.LoadApplicationConfig(filePath); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+
+    if checkOnly {
+        fmt.Println("Configuration OK")
+        return
+    }
+
+    conf := rasconfig.Current()
+
+    // After a hot restart the parent still holds the stores for a moment
+    rasrestart.WaitForParent()
+
+    if conf.LogFilePath != "" {
+        log.SetOutput(&lumberjack.Logger{
+            Filename:   conf.LogFilePath,
+            MaxBackups: 3,
+            MaxSize:    5,
+            MaxAge:     15,
+        })
+    }
+
+    mux := http.NewServeMux()
+    chatService, _ := installSocketMux(mux, *conf)
+    installHTTPRoutes(mux, *conf)
+    installHealthRoutes(mux, chatService)
+    installAdminRoutes(mux)
+
+    // Raw listeners by name, inherited from the parent after a hot restart
+    listeners := make(map[string]net.Listener)
+
+    if conf.IRCBindAddress != "" {
+        listener, err := rasrestart.Listen("irc", conf.IRCBindAddress)
+        if err != nil {
+            log.Panic(err)
+        }
+        listeners["irc"] = listener
+
+        go func() {
+            log.Println("Starting IRC gateway...", conf.IRCBindAddress)
+            log.Println("IRC gateway stopped", chatService.ServeIRC(listener))
+        }()
+    }
+
+    if conf.JSONBindAddress != "" {
+        tlsConfig, err := loadTLSConfig(conf, conf.JSONTLSCertFile, conf.JSONTLSKeyFile)
+        if err != nil {
+            log.Panic(err)
+        }
+
+        listener, err := rasrestart.Listen("json", conf.JSONBindAddress)
+        if err != nil {
+            log.Panic(err)
+        }
+        listeners["json"] = listener
+
+        go func() {
+            log.Println("Starting JSON line gateway...", conf.JSONBindAddress, "TLS:", tlsConfig != nil)
+            if tlsConfig != nil {
+                listener = tls.NewListener(listener, tlsConfig)
+            }
+            log.Println("JSON line gateway stopped", chatService.ServeJSON(listener))
+        }()
+    }
+
+    tlsConfig, err := loadTLSConfig(conf, conf.TLSCertFile, conf.TLSKeyFile)
+    if err != nil {
+        log.Panic(err)
+    }
+
+    server := &http.Server{
+        Addr:      conf.BindAddress,
+        Handler:   mux,
+        TLSConfig: tlsConfig,
+    }
+    servers := []*http.Server{server}
+
+    listener, err := rasrestart.Listen("http", conf.BindAddress)
+    if err != nil {
+        log.Panic(err)
+    }
+    listeners["http"] = listener
+
+    go func() {
+        log.Println("Starting server...", conf.BindAddress, "TLS:", tlsConfig != nil)
+        var err error
+        if tlsConfig != nil {
+            err = server.ServeTLS(listener, "", "")
+        } else {
+            err = server.Serve(listener)
+        }
+
+        if err != http.ErrServerClosed && !handedOff.Load() {
+            log.Panic(err)
+        }
+    }()
+
+    if conf.HTTPRedirectAddress != "" {
+        redirectServer := &http.Server{
+            Addr:    conf.HTTPRedirectAddress,
+            Handler: redirectToHTTPS(conf.BindAddress),
+        }
+        servers = append(servers, redirectServer)
+
+        redirectListener, err := rasrestart.Listen("redirect", conf.HTTPRedirectAddress)
+        if err != nil {
+            log.Panic(err)
+        }
+        listeners["redirect"] = redirectListener
+
+        go func() {
+            log.Println("Redirecting HTTP to HTTPS...", conf.HTTPRedirectAddress)
+            if err := redirectServer.Serve(redirectListener); err != http.ErrServerClosed && !handedOff.Load() {
+                log.Panic(err)
+            }
+        }()
+    }
+
+    timeout := cDefaultShutdownTimeout
+    if conf.ShutdownTimeout > 0 {
+        timeout = time.Duration(conf.ShutdownTimeout) * time.Second
+    }
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, pSignals...)
+    for sig := range signals {
+        if isReloadSignal(sig) {
+            log.Println("Received", sig, "reloading configuration...")
+            if _, err := rasconfig.Reload(); err != nil {
+                log.Println("Configuration reload failed", err)
+            }
+            continue
+        }
+
+        if !isRestartSignal(sig) {
+            log.Println("Received", sig, "shutting down...")
+            shutdown(servers, chatService, timeout)
+            break
+        }
+
+        if !conf.AllowHotRestart {
+            log.Println("Received", sig, "but allow_hot_reboot is off, ignoring")
+            continue
+        }
+
+        handoff, err := rasrestart.Prepare(listeners)
+        if err != nil {
+            log.Println("Unable to hand off listeners", err)
+            continue
+        }
+
+        log.Println("Received", sig, "restarting...")
+        if hotRestart(servers, listeners, chatService, timeout, handoff) {
+            break
+        }
+    }
+
+    log.Println("Shutdown complete")
+}
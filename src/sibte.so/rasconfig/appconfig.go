@@ -0,0 +1,244 @@
+package rasconfig
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/url"
+    "os"
+    "strings"
+    "sync/atomic"
+)
+
+type ApplicationConfig struct {
+    BindAddress          string                          `json:"bind_address"`
+    LogFilePath          string                          `json:"log_file"`
+    DBPath               string                          `json:"db_path"`
+    AllowHotRestart      bool                            `json:"allow_hot_reboot"`
+    AllowedOrigins       []string                        `json:"allowed_origins"`
+    ExternalSignIn       map[string]string               `json:"external_sign_in"`
+    WebSocketURL         string                          `json:"websocket_url"`
+    WebSocketSecureURL   string                          `json:"websocketsecure_url"`
+    HasAuthProviders     bool                            `json:"has_auth_providers"`
+    UploaderConfig       map[string]string               `json:"uploader_config"`
+    AppSecretKey         string                          `json:"secret"`
+    IRCBindAddress       string                          `json:"irc_bind_address"`
+    JSONBindAddress      string                          `json:"json_bind_address"`
+    JSONTLSCertFile      string                          `json:"json_tls_cert_file"`
+    JSONTLSKeyFile       string                          `json:"json_tls_key_file"`
+    PushConfig           map[string]map[string]string    `json:"push_config"`
+    RateLimits           map[string]map[string]RateLimit `json:"rate_limits"`
+    OutboundQueueSize    int                             `json:"outbound_queue_size"`
+    SlowClientPolicy     string                          `json:"slow_client_policy"`
+    MaxConnectionsPerIP  int                             `json:"max_connections_per_ip"`
+    ShutdownTimeout      int                             `json:"shutdown_timeout_seconds"`
+    BlackList            []string                        `json:"blacklist"`
+    WelcomeMessage       string                          `json:"welcome_message"`
+    TLSCertFile          string                          `json:"tls_cert_file"`
+    TLSKeyFile           string                          `json:"tls_key_file"`
+    TLSMinVersion        string                          `json:"tls_min_version"`
+    TLSClientCAFile      string                          `json:"tls_client_ca_file"`
+    TLSRequireClientCert bool                            `json:"tls_require_client_cert"`
+    HTTPRedirectAddress  string                          `json:"http_redirect_address"`
+    ClusterRedisURL      string                          `json:"cluster_redis_url"`
+    WorkerId             *int                            `json:"worker_id"`
+    IdleTimeout          int                             `json:"idle_timeout_seconds"`
+    DisableGifs          bool                            `json:"disable_gifs"`
+
+    // Deprecated: GCM was replaced by push_config, kept so older configs
+    // still load and only warned about
+    GCMToken string `json:"gcm_token"`
+}
+
+// String prints the configuration with the app secret, the Redis password and
+// every key, secret or password of the provider sections masked, so it can go
+// to the log
+func (c ApplicationConfig) String() string {
+    type plain ApplicationConfig
+    redacted := plain(c)
+    if redacted.AppSecretKey != "" {
+        redacted.AppSecretKey = cRedacted
+    }
+
+    if redacted.GCMToken != "" {
+        redacted.GCMToken = cRedacted
+    }
+
+    if u, err := url.Parse(c.ClusterRedisURL); err == nil && u.User != nil {
+        if _, ok := u.User.Password(); ok {
+            u.User = url.UserPassword(u.User.Username(), "redacted")
+            redacted.ClusterRedisURL = u.String()
+        }
+    }
+
+    redacted.UploaderConfig = redactSection(c.UploaderConfig)
+    redacted.PushConfig = make(map[string]map[string]string, len(c.PushConfig))
+    for platform, section := range c.PushConfig {
+        redacted.PushConfig[platform] = redactSection(section)
+    }
+
+    return fmt.Sprintf("%+v", redacted)
+}
+
+const cRedacted = "<redacted>"
+
+func redactSection(section map[string]string) map[string]string {
+    ret := make(map[string]string, len(section))
+    for k, v := range section {
+        name := strings.ToLower(k)
+        if strings.Contains(name, "key") || strings.Contains(name, "secret") || strings.Contains(name, "password") {
+            v = cRedacted
+        }
+        ret[k] = v
+    }
+
+    return ret
+}
+
+// RateLimit is a token bucket refilled at Rate tokens per second up to Burst
+type RateLimit struct {
+    Rate  float64 `json:"rate"`
+    Burst int     `json:"burst"`
+}
+
+// Rate limited actions and the scopes each can be limited on
+const (
+    RateLimitMessage = "message"
+    RateLimitJoin    = "join"
+    RateLimitNick    = "nick"
+    RateLimitUpload  = "upload"
+
+    RateScopeConnection = "connection"
+    RateScopeUser       = "user"
+    RateScopeIP         = "ip"
+)
+
+func defaultRateLimits() map[string]map[string]RateLimit {
+    return map[string]map[string]RateLimit{
+        RateLimitMessage: {
+            RateScopeConnection: {Rate: 2, Burst: 10},
+            RateScopeUser:       {Rate: 3, Burst: 15},
+            RateScopeIP:         {Rate: 10, Burst: 50},
+        },
+        RateLimitJoin: {
+            RateScopeConnection: {Rate: 0.5, Burst: 10},
+            RateScopeUser:       {Rate: 0.5, Burst: 10},
+            RateScopeIP:         {Rate: 2, Burst: 30},
+        },
+        RateLimitNick: {
+            RateScopeConnection: {Rate: 0.1, Burst: 3},
+            RateScopeUser:       {Rate: 0.1, Burst: 3},
+            RateScopeIP:         {Rate: 0.5, Burst: 10},
+        },
+        RateLimitUpload: {
+            RateScopeIP: {Rate: 0.2, Burst: 5},
+        },
+    }
+}
+
+// applyRateLimitDefaults fills every action/scope missing from the config,
+// a configured rate of 0 disables that limit
+func applyRateLimitDefaults(conf *ApplicationConfig) {
+    if conf.RateLimits == nil {
+        conf.RateLimits = make(map[string]map[string]RateLimit)
+    }
+
+    for action, scopes := range defaultRateLimits() {
+        if conf.RateLimits[action] == nil {
+            conf.RateLimits[action] = make(map[string]RateLimit)
+        }
+
+        for scope, limit := range scopes {
+            if _, ok := conf.RateLimits[action][scope]; !ok {
+                conf.RateLimits[action][scope] = limit
+            }
+        }
+    }
+}
+
+// pCurrent holds the *ApplicationConfig in effect, reloads swap in a new
+// pointer so readers always see a complete configuration
+var pCurrent atomic.Value
+
+// Current returns the configuration in effect, it must be treated as read only
+func Current() *ApplicationConfig {
+    conf, _ := pCurrent.Load().(*ApplicationConfig)
+    if conf == nil {
+        return &ApplicationConfig{}
+    }
+
+    return conf
+}
+
+// LoadApplicationConfig loads filePath, or the defaults when it is empty,
+// applies RASPCHAT_* environment overrides and validates the result
+func LoadApplicationConfig(filePath string) error {
+    conf, err := loadConfig(filePath)
+    if err != nil {
+        return err
+    }
+
+    pLoadedFrom = filePath
+    pCurrent.Store(conf)
+    log.Println("=== Loaded configuration")
+    log.Println(conf)
+    return nil
+}
+
+func loadConfig(filePath string) (*ApplicationConfig, error) {
+    conf := &ApplicationConfig{}
+    if filePath == "" {
+        if err := applyDefaults(conf); err != nil {
+            return nil, err
+        }
+    } else if err := readConfigFile(filePath, conf); err != nil {
+        return nil, fmt.Errorf("%s: %v", filePath, err)
+    }
+
+    if err := applyEnvOverrides(conf, os.LookupEnv); err != nil {
+        return nil, err
+    }
+
+    if conf.GCMToken != "" {
+        log.Println("gcm_token is deprecated and ignored, configure push_config instead")
+    }
+
+    conf.HasAuthProviders = len(conf.ExternalSignIn) != 0
+    applyRateLimitDefaults(conf)
+    if err := Validate(conf); err != nil {
+        return nil, err
+    }
+
+    return conf, nil
+}
+
+func applyDefaults(conf *ApplicationConfig) error {
+    dir, err := ioutil.TempDir("", "raspchat")
+    if err != nil {
+        return err
+    }
+
+    conf.AllowHotRestart = false
+    conf.BindAddress = ":8080"
+    conf.DBPath = dir
+    conf.LogFilePath = ""
+    conf.AllowedOrigins = make([]string, 0)
+    conf.ExternalSignIn = make(map[string]string)
+    conf.HasAuthProviders = false
+    conf.WebSocketURL = ""
+    conf.WebSocketSecureURL = ""
+
+    conf.UploaderConfig = make(map[string]string)
+    conf.UploaderConfig["provider"] = "local"
+    conf.UploaderConfig["disk_storage_path"] = dir
+    return nil
+}
+
+func readConfigFile(filePath string, conf *ApplicationConfig) error {
+    content, err := ioutil.ReadFile(filePath)
+    if err != nil {
+        return err
+    }
+
+    return decodeConfig(filePath, content, conf)
+}
@@ -0,0 +1,73 @@
+package rasconfig
+
+import (
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// Settings are overridden by RASPCHAT_ followed by the upper cased key,
+// e.g. RASPCHAT_BIND_ADDRESS. Lists take JSON or comma separated values,
+// maps take JSON
+const cEnvPrefix = "RASPCHAT_"
+
+func applyEnvOverrides(conf *ApplicationConfig, lookup func(string) (string, bool)) error {
+    v := reflect.ValueOf(conf).Elem()
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+        name := cEnvPrefix + strings.ToUpper(key)
+        value, ok := lookup(name)
+        if !ok {
+            continue
+        }
+
+        if err := setFromEnv(v.Field(i), value); err != nil {
+            return fmt.Errorf("%s: %v", name, err)
+        }
+    }
+
+    return nil
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(value)
+        return nil
+    case reflect.Bool:
+        b, err := strconv.ParseBool(value)
+        if err != nil {
+            return fmt.Errorf("%q is not a boolean", value)
+        }
+        field.SetBool(b)
+        return nil
+    case reflect.Int:
+        n, err := strconv.Atoi(value)
+        if err != nil {
+            return fmt.Errorf("%q is not an integer", value)
+        }
+        field.SetInt(int64(n))
+        return nil
+    case reflect.Slice:
+        if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+            items := []string{}
+            for _, item := range strings.Split(value, ",") {
+                if item = strings.TrimSpace(item); item != "" {
+                    items = append(items, item)
+                }
+            }
+            field.Set(reflect.ValueOf(items))
+            return nil
+        }
+    }
+
+    ptr := reflect.New(field.Type())
+    if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+        return fmt.Errorf("expected JSON %s: %v", field.Type(), err)
+    }
+    field.Set(ptr.Elem())
+    return nil
+}
@@ -0,0 +1,82 @@
+package rasconfig
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v2"
+)
+
+// decodeConfig decodes JSON, YAML or TOML content, picked by the file
+// extension. Every format goes through the JSON keys so they stay the same
+// and keys that don't match a setting are rejected
+func decodeConfig(filePath string, content []byte, conf *ApplicationConfig) error {
+    var err error
+    switch strings.ToLower(filepath.Ext(filePath)) {
+    case ".yaml", ".yml":
+        var doc interface{}
+        if err = yaml.Unmarshal(content, &doc); err != nil {
+            return err
+        }
+
+        if content, err = json.Marshal(normalizeYAML(doc)); err != nil {
+            return err
+        }
+    case ".toml":
+        doc := make(map[string]interface{})
+        if _, err = toml.Decode(string(content), &doc); err != nil {
+            return err
+        }
+
+        if content, err = json.Marshal(doc); err != nil {
+            return err
+        }
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(content))
+    decoder.DisallowUnknownFields()
+    if err = decoder.Decode(conf); err != nil {
+        return describeDecodeError(content, err)
+    }
+
+    return nil
+}
+
+// normalizeYAML turns the map[interface{}]interface{} values yaml produces
+// into maps json can encode
+func normalizeYAML(v interface{}) interface{} {
+    switch t := v.(type) {
+    case map[interface{}]interface{}:
+        ret := make(map[string]interface{}, len(t))
+        for k, val := range t {
+            ret[fmt.Sprint(k)] = normalizeYAML(val)
+        }
+        return ret
+    case []interface{}:
+        for i, val := range t {
+            t[i] = normalizeYAML(val)
+        }
+    }
+
+    return v
+}
+
+func describeDecodeError(content []byte, err error) error {
+    switch t := err.(type) {
+    case *json.SyntaxError:
+        line := 1 + bytes.Count(content[:t.Offset], []byte("\n"))
+        return fmt.Errorf("syntax error on line %d: %v", line, err)
+    case *json.UnmarshalTypeError:
+        return fmt.Errorf("%s must be %s, got %s", t.Field, t.Type, t.Value)
+    }
+
+    if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+        return fmt.Errorf("unknown key %s", strings.TrimPrefix(msg, "json: unknown field "))
+    }
+
+    return err
+}
@@ -0,0 +1,63 @@
+package rasconfig
+
+import (
+    "errors"
+    "log"
+    "reflect"
+    "sync"
+)
+
+var errNoConfigFile = errors.New("No configuration file to reload")
+
+// Path of the file the configuration was loaded from, empty for defaults
+var pLoadedFrom string
+
+var pReload struct {
+    sync.Mutex
+    listeners []func(*ApplicationConfig)
+}
+
+// OnReload registers fn to be called with the new configuration after every
+// successful reload
+func OnReload(fn func(*ApplicationConfig)) {
+    pReload.Lock()
+    defer pReload.Unlock()
+
+    pReload.listeners = append(pReload.listeners, fn)
+}
+
+// Reload reads the configuration file again and applies the settings that can
+// change at runtime: allowed origins, rate limits, uploader config, blacklist
+// and welcome message. Everything else keeps its value until restart
+func Reload() (*ApplicationConfig, error) {
+    pReload.Lock()
+    defer pReload.Unlock()
+
+    if pLoadedFrom == "" {
+        return nil, errNoConfigFile
+    }
+
+    loaded, err := loadConfig(pLoadedFrom)
+    if err != nil {
+        return nil, err
+    }
+
+    conf := *Current()
+    conf.AllowedOrigins = loaded.AllowedOrigins
+    conf.RateLimits = loaded.RateLimits
+    conf.UploaderConfig = loaded.UploaderConfig
+    conf.BlackList = loaded.BlackList
+    conf.WelcomeMessage = loaded.WelcomeMessage
+
+    if !reflect.DeepEqual(&conf, loaded) {
+        log.Println("Some changed settings only take effect after a restart")
+    }
+
+    pCurrent.Store(&conf)
+    for _, fn := range pReload.listeners {
+        fn(&conf)
+    }
+
+    log.Println("=== Reloaded configuration")
+    return &conf, nil
+}
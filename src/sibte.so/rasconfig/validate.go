@@ -0,0 +1,230 @@
+package rasconfig
+
+import (
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Snowflake ids reserve 10 bits for the worker id
+const cMaxWorkerId = 1023
+
+// Fields each uploader provider needs in uploader_config
+var pUploaderRequiredFields = map[string][]string{
+    "local": {"disk_storage_path"},
+    "azure": {"account_name", "account_key", "container"},
+}
+
+var pRateLimitScopes = map[string][]string{
+    RateLimitMessage: {RateScopeConnection, RateScopeUser, RateScopeIP},
+    RateLimitJoin:    {RateScopeConnection, RateScopeUser, RateScopeIP},
+    RateLimitNick:    {RateScopeConnection, RateScopeUser, RateScopeIP},
+    RateLimitUpload:  {RateScopeIP},
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+    Problems []string
+}
+
+func (e *ValidationError) Error() string {
+    return "Invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+    e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks conf and reports all problems at once
+func Validate(conf *ApplicationConfig) error {
+    v := &ValidationError{}
+
+    v.checkAddress("bind_address", conf.BindAddress, true)
+    v.checkAddress("irc_bind_address", conf.IRCBindAddress, false)
+    v.checkAddress("json_bind_address", conf.JSONBindAddress, false)
+
+    if conf.DBPath == "" {
+        v.add("db_path is required")
+    }
+
+    v.checkAddress("http_redirect_address", conf.HTTPRedirectAddress, false)
+
+    if (conf.TLSCertFile == "") != (conf.TLSKeyFile == "") {
+        v.add("tls_cert_file and tls_key_file must be set together")
+    }
+    v.checkFile("tls_cert_file", conf.TLSCertFile)
+    v.checkFile("tls_key_file", conf.TLSKeyFile)
+    v.checkFile("tls_client_ca_file", conf.TLSClientCAFile)
+
+    if conf.TLSCertFile == "" && (conf.TLSClientCAFile != "" || conf.HTTPRedirectAddress != "") {
+        v.add("tls_client_ca_file and http_redirect_address need tls_cert_file and tls_key_file")
+    }
+
+    if conf.TLSRequireClientCert && conf.TLSClientCAFile == "" {
+        v.add("tls_require_client_cert needs tls_client_ca_file")
+    }
+
+    switch conf.TLSMinVersion {
+    case "", "1.0", "1.1", "1.2", "1.3":
+    default:
+        v.add("tls_min_version %q must be one of 1.0, 1.1, 1.2 or 1.3", conf.TLSMinVersion)
+    }
+
+    if (conf.JSONTLSCertFile == "") != (conf.JSONTLSKeyFile == "") {
+        v.add("json_tls_cert_file and json_tls_key_file must be set together")
+    }
+    v.checkFile("json_tls_cert_file", conf.JSONTLSCertFile)
+    v.checkFile("json_tls_key_file", conf.JSONTLSKeyFile)
+
+    v.checkUploader(conf.UploaderConfig)
+    v.checkRateLimits(conf.RateLimits)
+
+    if conf.OutboundQueueSize < 0 {
+        v.add("outbound_queue_size must not be negative")
+    }
+
+    if conf.MaxConnectionsPerIP < 0 {
+        v.add("max_connections_per_ip must not be negative")
+    }
+
+    if conf.ShutdownTimeout < 0 {
+        v.add("shutdown_timeout_seconds must not be negative")
+    }
+
+    if conf.IdleTimeout < 0 {
+        v.add("idle_timeout_seconds must not be negative")
+    }
+
+    if conf.WorkerId != nil && (*conf.WorkerId < 0 || *conf.WorkerId > cMaxWorkerId) {
+        v.add("worker_id must be between 0 and %d", cMaxWorkerId)
+    }
+
+    if conf.ClusterRedisURL != "" {
+        if u, err := url.Parse(conf.ClusterRedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+            v.add("cluster_redis_url %q must look like redis://host:port", conf.ClusterRedisURL)
+        }
+
+        if conf.WorkerId == nil {
+            v.add("worker_id is required with cluster_redis_url, every node needs its own")
+        }
+    }
+
+    switch conf.SlowClientPolicy {
+    case "", "drop-oldest", "disconnect":
+    default:
+        v.add("slow_client_policy %q must be drop-oldest or disconnect", conf.SlowClientPolicy)
+    }
+
+    for _, origin := range conf.AllowedOrigins {
+        if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+            v.add("allowed_origins entry %q is not an origin like https://example.com", origin)
+        }
+    }
+
+    for _, ip := range conf.BlackList {
+        if net.ParseIP(ip) == nil {
+            v.add("blacklist entry %q is not an IP address", ip)
+        }
+    }
+
+    if len(v.Problems) == 0 {
+        return nil
+    }
+
+    return v
+}
+
+func (v *ValidationError) checkAddress(key, addr string, required bool) {
+    if addr == "" {
+        if required {
+            v.add("%s is required", key)
+        }
+        return
+    }
+
+    _, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        v.add("%s %q must look like host:port or :port", key, addr)
+        return
+    }
+
+    if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+        v.add("%s %q has an invalid port", key, addr)
+    }
+}
+
+func (v *ValidationError) checkFile(key, path string) {
+    if path == "" {
+        return
+    }
+
+    if _, err := os.Stat(path); err != nil {
+        v.add("%s: %v", key, err)
+    }
+}
+
+func (v *ValidationError) checkUploader(cfg map[string]string) {
+    if len(cfg) == 0 {
+        return
+    }
+
+    provider := cfg["provider"]
+    required, ok := pUploaderRequiredFields[provider]
+    if !ok {
+        v.add("uploader_config.provider %q must be local or azure", provider)
+        return
+    }
+
+    for _, field := range required {
+        if cfg[field] == "" {
+            v.add("uploader_config.%s is required for the %s provider", field, provider)
+        }
+    }
+}
+
+func (v *ValidationError) checkRateLimits(limits map[string]map[string]RateLimit) {
+    actions := make([]string, 0, len(limits))
+    for action := range limits {
+        actions = append(actions, action)
+    }
+    sort.Strings(actions)
+
+    for _, action := range actions {
+        known, ok := pRateLimitScopes[action]
+        if !ok {
+            v.add("rate_limits.%s is not a rate limited action", action)
+            continue
+        }
+
+        scopes := make([]string, 0, len(limits[action]))
+        for scope := range limits[action] {
+            scopes = append(scopes, scope)
+        }
+        sort.Strings(scopes)
+
+        for _, scope := range scopes {
+            limit := limits[action][scope]
+            if !containsString(known, scope) {
+                v.add("rate_limits.%s.%s is not a scope of %s", action, scope, action)
+            }
+
+            if limit.Rate < 0 || limit.Burst < 0 {
+                v.add("rate_limits.%s.%s must not be negative", action, scope)
+            }
+        }
+    }
+}
+
+func containsString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+const (
+    cWriteTimeout = 10 * time.Second
+
+    // Marks the messages of a run, followed by the send time and a sequence
+    cLoadPrefix = "load "
+)
+
+// event holds the fields of server events the load generator looks at
+type event struct {
+    Name      string `json:"@"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+    Message   string `json:"msg"`
+    NewNick   string `json:"newNick"`
+    Type      int64  `json:"t"`
+    ErrorType string `json:"error_type"`
+}
+
+// loadClient is one websocket connection of the load run
+type loadClient struct {
+    writeLock sync.Mutex
+    conn      *websocket.Conn
+    stats     *loadStats
+    groups    []int
+    nick      string
+    ready     chan struct{}
+    joined    chan string
+    closing   chan struct{}
+    closeOnce sync.Once
+}
+
+// dialClient connects to url and waits until the server assigned a nick
+func dialClient(url, origin string, groups []int, stats *loadStats, timeout time.Duration) (*loadClient, error) {
+    header := http.Header{}
+    if origin != "" {
+        header.Set("Origin", origin)
+    }
+
+    dialer := &websocket.Dialer{HandshakeTimeout: timeout}
+    conn, resp, err := dialer.Dial(url, header)
+    if err == websocket.ErrBadHandshake && resp != nil {
+        return nil, fmt.Errorf("%v: %s", err, resp.Status)
+    } else if err != nil {
+        return nil, err
+    }
+
+    c := &loadClient{
+        conn:    conn,
+        stats:   stats,
+        groups:  groups,
+        ready:   make(chan struct{}),
+        joined:  make(chan string, len(groups)),
+        closing: make(chan struct{}),
+    }
+    go c.readLoop()
+
+    select {
+    case <-c.ready:
+        return c, nil
+    case <-time.After(timeout):
+        c.Close()
+        return nil, errors.New("Timed out waiting for a nick")
+    }
+}
+
+func (c *loadClient) readLoop() {
+    for {
+        _, body, err := c.conn.ReadMessage()
+        if err != nil {
+            select {
+            case <-c.closing:
+            default:
+                c.stats.onDisconnect(err)
+            }
+            return
+        }
+
+        // Events with other shapes of the fields above are of no interest
+        var e event
+        if json.Unmarshal(body, &e) == nil {
+            c.onEvent(&e, time.Now())
+        }
+    }
+}
+
+func (c *loadClient) onEvent(e *event, now time.Time) {
+    switch e.Name {
+    case "nick-set":
+        if c.nick == "" {
+            c.nick = e.NewNick
+            close(c.ready)
+        }
+    case "group-join":
+        if e.From == c.nick {
+            c.joined <- e.To
+        }
+    case "group-message":
+        if sent, ok := parseSendTime(e.Message); ok {
+            c.stats.onDelivered(now.Sub(sent))
+        }
+    case "ping":
+        c.send(map[string]interface{}{"@": "pong", "t": e.Type})
+    case "error-msg":
+        c.stats.onError(e.ErrorType)
+    }
+}
+
+func (c *loadClient) send(msg interface{}) error {
+    c.writeLock.Lock()
+    defer c.writeLock.Unlock()
+
+    c.conn.SetWriteDeadline(time.Now().Add(cWriteTimeout))
+    return c.conn.WriteJSON(msg)
+}
+
+// join joins every group of the client and waits until the server confirmed
+func (c *loadClient) join(names []string, timeout time.Duration) error {
+    for _, g := range c.groups {
+        if err := c.send(map[string]string{"@": "join-group", "msg": names[g]}); err != nil {
+            return err
+        }
+    }
+
+    deadline := time.After(timeout)
+    for range c.groups {
+        select {
+        case <-c.joined:
+        case <-deadline:
+            return errors.New("Timed out joining groups")
+        }
+    }
+
+    return nil
+}
+
+// sendLoop sends a message of size bytes to the client's groups in turn
+// every interval until stop is closed
+func (c *loadClient) sendLoop(names []string, interval time.Duration, size int, stop <-chan struct{}) {
+    // Spread clients over the interval instead of sending in bursts
+    select {
+    case <-time.After(time.Duration(rand.Int63n(int64(interval)))):
+    case <-stop:
+        return
+    }
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for seq := 0; ; seq++ {
+        g := c.groups[seq%len(c.groups)]
+        msg := map[string]string{
+            "@":   "send-msg",
+            "to":  names[g],
+            "msg": loadMessage(time.Now(), seq, size),
+        }
+
+        if err := c.send(msg); err != nil {
+            return
+        }
+        c.stats.onSent(g)
+
+        select {
+        case <-ticker.C:
+        case <-stop:
+            return
+        }
+    }
+}
+
+func (c *loadClient) Close() {
+    c.closeOnce.Do(func() {
+        close(c.closing)
+        c.conn.Close()
+    })
+}
+
+// loadMessage formats a message carrying its send time padded to size bytes
+func loadMessage(sent time.Time, seq, size int) string {
+    msg := fmt.Sprintf("%s%d %d ", cLoadPrefix, sent.UnixNano(), seq)
+    if len(msg) < size {
+        msg += strings.Repeat("x", size-len(msg))
+    }
+
+    return msg
+}
+
+// parseSendTime extracts the send time of a load message
+func parseSendTime(msg string) (time.Time, bool) {
+    if !strings.HasPrefix(msg, cLoadPrefix) {
+        return time.Time{}, false
+    }
+
+    fields := strings.Fields(msg)
+    if len(fields) < 2 {
+        return time.Time{}, false
+    }
+
+    ns, err := strconv.ParseInt(fields[1], 10, 64)
+    if err != nil {
+        return time.Time{}, false
+    }
+
+    return time.Unix(0, ns), true
+}
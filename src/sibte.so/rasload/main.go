@@ -0,0 +1,235 @@
+// rasload is a load generator for the chat server. It opens a number of
+// websocket clients, joins them to groups, sends group messages at a fixed
+// overall rate and reports connection times, delivery latency and loss
+package main
+
+/*
+Copyright (c) 2015 Zohaib
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// The server drops messages longer than this
+const cMaxMessageSize = 512
+
+type loadConfig struct {
+    url             string
+    origin          string
+    clients         int
+    groups          int
+    groupsPerClient int
+    rate            float64
+    size            int
+    connectRate     float64
+    duration        time.Duration
+    drain           time.Duration
+    timeout         time.Duration
+}
+
+func parseFlags() *loadConfig {
+    conf := &loadConfig{}
+    flag.StringVar(&conf.url, "url", "ws://localhost:8080/chat", "Websocket endpoint of the chat server")
+    flag.StringVar(&conf.origin, "origin", "", "Origin header to send, needed when the server sets allowed_origins")
+    flag.IntVar(&conf.clients, "clients", 100, "Number of websocket clients")
+    flag.IntVar(&conf.groups, "groups", 10, "Number of groups the clients are spread over")
+    flag.IntVar(&conf.groupsPerClient, "groups-per-client", 1, "Number of groups each client joins")
+    flag.Float64Var(&conf.rate, "rate", 100, "Messages per second sent by all clients together")
+    flag.IntVar(&conf.size, "size", 64, "Message size in bytes")
+    flag.Float64Var(&conf.connectRate, "connect-rate", 100, "New connections per second while connecting")
+    flag.DurationVar(&conf.duration, "duration", 30*time.Second, "How long to send messages")
+    flag.DurationVar(&conf.drain, "drain", 5*time.Second, "How long to wait for deliveries after sending stopped")
+    flag.DurationVar(&conf.timeout, "timeout", 10*time.Second, "Timeout for connecting and joining")
+    flag.Parse()
+
+    switch {
+    case conf.clients < 1:
+        log.Fatal("-clients must be at least 1")
+    case conf.groups < 1:
+        log.Fatal("-groups must be at least 1")
+    case conf.groupsPerClient < 1 || conf.groupsPerClient > conf.groups:
+        log.Fatal("-groups-per-client must be between 1 and -groups")
+    case conf.rate < 0:
+        log.Fatal("-rate can't be negative")
+    case conf.size > cMaxMessageSize:
+        log.Fatalf("-size can be at most %d", cMaxMessageSize)
+    case conf.connectRate <= 0:
+        log.Fatal("-connect-rate must be positive")
+    }
+
+    return conf
+}
+
+func groupNames(n int) []string {
+    ret := make([]string, n)
+    for i := range ret {
+        ret[i] = fmt.Sprintf("load-%d", i)
+    }
+
+    return ret
+}
+
+// groupsOf spreads clients over groups round robin
+func groupsOf(client int, conf *loadConfig) []int {
+    ret := make([]int, conf.groupsPerClient)
+    for j := range ret {
+        ret[j] = (client*conf.groupsPerClient + j) % conf.groups
+    }
+
+    return ret
+}
+
+// connectAll dials every client at connectRate and joins their groups,
+// clients that fail are counted and left out
+func connectAll(conf *loadConfig, names []string, stats *loadStats) []*loadClient {
+    var wg sync.WaitGroup
+    var lock sync.Mutex
+    clients := make([]*loadClient, 0, conf.clients)
+
+    ticker := time.NewTicker(time.Duration(float64(time.Second) / conf.connectRate))
+    defer ticker.Stop()
+
+    for i := 0; i < conf.clients; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+
+            start := time.Now()
+            c, err := dialClient(conf.url, conf.origin, groupsOf(i, conf), stats, conf.timeout)
+            if err == nil {
+                stats.connectLatency.Observe(time.Since(start))
+                if err = c.join(names, conf.timeout); err != nil {
+                    c.Close()
+                }
+            }
+
+            lock.Lock()
+            defer lock.Unlock()
+            if err != nil {
+                if stats.connectFailures++; stats.connectFailures == 1 {
+                    log.Println("First connection failure:", err)
+                }
+                return
+            }
+            clients = append(clients, c)
+        }(i)
+
+        if i+1 < conf.clients {
+            <-ticker.C
+        }
+    }
+
+    wg.Wait()
+    return clients
+}
+
+func formatPercentiles(s *latencySample) string {
+    ps := []float64{50, 90, 99, 99.9}
+    values := s.Percentiles(ps...)
+    ret := ""
+    for i, p := range ps {
+        ret += fmt.Sprintf("p%v %v  ", p, values[i])
+    }
+
+    return ret + fmt.Sprintf("max %v", s.Max())
+}
+
+func report(conf *loadConfig, clients []*loadClient, stats *loadStats, elapsed time.Duration) {
+    members := make([]int, conf.groups)
+    for _, c := range clients {
+        for _, g := range c.groups {
+            members[g]++
+        }
+    }
+
+    sent, expected := stats.expected(members)
+    delivered := atomic.LoadInt64(&stats.delivered)
+    lost := expected - delivered
+    lossPercent := 0.0
+    if expected > 0 {
+        lossPercent = float64(lost) * 100 / float64(expected)
+    }
+
+    fmt.Printf("Clients      %d connected, %d failed, %d disconnected\n", len(clients), stats.connectFailures, atomic.LoadInt64(&stats.disconnects))
+    fmt.Printf("Connect      %s\n", formatPercentiles(stats.connectLatency))
+    fmt.Printf("Messages     %d sent in %v (%.1f/s)\n", sent, elapsed, float64(sent)/elapsed.Seconds())
+    fmt.Printf("Deliveries   %d expected, %d received (%.1f/s), %d lost (%.2f%%)\n",
+        expected, delivered, float64(delivered)/elapsed.Seconds(), lost, lossPercent)
+    fmt.Printf("Latency      %s\n", formatPercentiles(stats.deliveryLatency))
+
+    if len(stats.errors) > 0 {
+        types := make([]string, 0, len(stats.errors))
+        for t := range stats.errors {
+            types = append(types, t)
+        }
+        sort.Strings(types)
+
+        for _, t := range types {
+            fmt.Printf("Errors       %s: %d\n", t, stats.errors[t])
+        }
+    }
+}
+
+func main() {
+    conf := parseFlags()
+    names := groupNames(conf.groups)
+    stats := newLoadStats(conf.groups)
+
+    log.Printf("Connecting %d clients to %s", conf.clients, conf.url)
+    clients := connectAll(conf, names, stats)
+    if len(clients) == 0 {
+        log.Println("No client connected")
+        os.Exit(1)
+    }
+
+    log.Printf("Sending %.1f messages/s for %v", conf.rate, conf.duration)
+    stop := make(chan struct{})
+    var senders sync.WaitGroup
+    if conf.rate > 0 {
+        // Every client sends at its share of the overall rate
+        interval := time.Duration(float64(time.Second) * float64(len(clients)) / conf.rate)
+        if interval <= 0 {
+            interval = time.Nanosecond
+        }
+
+        for _, c := range clients {
+            senders.Add(1)
+            go func(c *loadClient) {
+                defer senders.Done()
+                c.sendLoop(names, interval, conf.size, stop)
+            }(c)
+        }
+    }
+
+    start := time.Now()
+    time.Sleep(conf.duration)
+    close(stop)
+    senders.Wait()
+    elapsed := time.Since(start)
+
+    log.Printf("Waiting %v for deliveries", conf.drain)
+    time.Sleep(conf.drain)
+
+    for _, c := range clients {
+        c.Close()
+    }
+
+    stats.Lock()
+    defer stats.Unlock()
+    report(conf, clients, stats, elapsed)
+
+    if atomic.LoadInt64(&stats.disconnects) > 0 || stats.connectFailures > 0 {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "log"
+    "math/rand"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Number of latencies kept by a latencySample
+const cSampleCapacity = 100000
+
+// latencySample keeps a uniform random sample of the observed durations
+// (reservoir sampling) so percentiles cost the same for any run length
+type latencySample struct {
+    sync.Mutex
+    seen   int64
+    max    time.Duration
+    values []time.Duration
+    rnd    *rand.Rand
+}
+
+func newLatencySample() *latencySample {
+    return &latencySample{
+        values: make([]time.Duration, 0, 1024),
+        rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+    }
+}
+
+func (s *latencySample) Observe(d time.Duration) {
+    s.Lock()
+    defer s.Unlock()
+
+    s.seen++
+    if d > s.max {
+        s.max = d
+    }
+
+    if len(s.values) < cSampleCapacity {
+        s.values = append(s.values, d)
+        return
+    }
+
+    if i := s.rnd.Int63n(s.seen); i < cSampleCapacity {
+        s.values[i] = d
+    }
+}
+
+// Percentiles returns the duration below which each of ps (0 to 100) of the
+// observations fall, zeros if nothing was observed
+func (s *latencySample) Percentiles(ps ...float64) []time.Duration {
+    s.Lock()
+    sorted := make([]time.Duration, len(s.values))
+    copy(sorted, s.values)
+    s.Unlock()
+
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+    ret := make([]time.Duration, len(ps))
+    if len(sorted) == 0 {
+        return ret
+    }
+
+    for i, p := range ps {
+        rank := int(p / 100 * float64(len(sorted)))
+        if rank >= len(sorted) {
+            rank = len(sorted) - 1
+        }
+        ret[i] = sorted[rank]
+    }
+
+    return ret
+}
+
+// Max is the longest duration observed
+func (s *latencySample) Max() time.Duration {
+    s.Lock()
+    defer s.Unlock()
+
+    return s.max
+}
+
+// loadStats is shared by every client of a run
+type loadStats struct {
+    sync.Mutex
+    connectFailures int64
+    disconnects     int64
+    sent            []int64
+    delivered       int64
+    connectLatency  *latencySample
+    deliveryLatency *latencySample
+    errors          map[string]int
+}
+
+func newLoadStats(groups int) *loadStats {
+    return &loadStats{
+        sent:            make([]int64, groups),
+        connectLatency:  newLatencySample(),
+        deliveryLatency: newLatencySample(),
+        errors:          make(map[string]int),
+    }
+}
+
+func (s *loadStats) onSent(group int) {
+    atomic.AddInt64(&s.sent[group], 1)
+}
+
+func (s *loadStats) onDelivered(latency time.Duration) {
+    atomic.AddInt64(&s.delivered, 1)
+    s.deliveryLatency.Observe(latency)
+}
+
+func (s *loadStats) onDisconnect(err error) {
+    if atomic.AddInt64(&s.disconnects, 1) == 1 {
+        log.Println("First disconnect:", err)
+    }
+}
+
+func (s *loadStats) onError(errorType string) {
+    s.Lock()
+    defer s.Unlock()
+
+    s.errors[errorType]++
+}
+
+// expected returns how many deliveries the messages sent so far should have
+// caused, members holds the number of clients in each group
+func (s *loadStats) expected(members []int) (sent, deliveries int64) {
+    for g := range s.sent {
+        n := atomic.LoadInt64(&s.sent[g])
+        sent += n
+        deliveries += n * int64(members[g])
+    }
+
+    return
+}
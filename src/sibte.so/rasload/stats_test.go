@@ -0,0 +1,52 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestPercentiles(t *testing.T) {
+    s := newLatencySample()
+    for i := 100; i >= 1; i-- {
+        s.Observe(time.Duration(i) * time.Millisecond)
+    }
+
+    got := s.Percentiles(0, 50, 99, 100)
+    want := []time.Duration{1 * time.Millisecond, 51 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}
+    for i := range want {
+        if got[i] != want[i] {
+            t.Errorf("Percentile %d is %v, expected %v", i, got[i], want[i])
+        }
+    }
+
+    if s.Max() != 100*time.Millisecond {
+        t.Error("Unexpected max", s.Max())
+    }
+}
+
+func TestSampleStaysBounded(t *testing.T) {
+    s := newLatencySample()
+    for i := 0; i < 2*cSampleCapacity; i++ {
+        s.Observe(time.Duration(i))
+    }
+
+    if len(s.values) != cSampleCapacity {
+        t.Error("Sample grew to", len(s.values))
+    }
+}
+
+func TestLoadMessageRoundTrip(t *testing.T) {
+    sent := time.Unix(0, 1500000000123456789)
+    msg := loadMessage(sent, 7, 64)
+    if len(msg) != 64 {
+        t.Error("Message is", len(msg), "bytes")
+    }
+
+    if got, ok := parseSendTime(msg); !ok || !got.Equal(sent) {
+        t.Error("Parsed", got, ok)
+    }
+
+    if _, ok := parseSendTime("hello"); ok {
+        t.Error("Parsed a message that isn't from the load generator")
+    }
+}
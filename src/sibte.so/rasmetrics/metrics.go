@@ -0,0 +1,306 @@
+package rasmetrics
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultBuckets suit latencies measured in seconds
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type collector interface {
+    write(w io.Writer)
+}
+
+type Registry struct {
+    sync.Mutex
+    collectors map[string]collector
+}
+
+var pDefaultRegistry = &Registry{
+    collectors: make(map[string]collector),
+}
+
+func (r *Registry) register(name string, c collector) {
+    r.Lock()
+    defer r.Unlock()
+
+    if _, ok := r.collectors[name]; ok {
+        panic("Duplicate metric " + name)
+    }
+    r.collectors[name] = c
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        pDefaultRegistry.write(w)
+    })
+}
+
+func (r *Registry) write(w io.Writer) {
+    r.Lock()
+    names := make([]string, 0, len(r.collectors))
+    for name := range r.collectors {
+        names = append(names, name)
+    }
+    r.Unlock()
+
+    sort.Strings(names)
+    for _, name := range names {
+        r.Lock()
+        c := r.collectors[name]
+        r.Unlock()
+        c.write(w)
+    }
+}
+
+// vec keeps one child per distinct label value tuple
+type vec struct {
+    sync.Mutex
+    name     string
+    help     string
+    kind     string
+    labels   []string
+    children map[string]interface{}
+    keys     map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+    return &vec{
+        name:     name,
+        help:     help,
+        kind:     kind,
+        labels:   labels,
+        children: make(map[string]interface{}),
+        keys:     make(map[string][]string),
+    }
+}
+
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+    if len(values) != len(v.labels) {
+        panic(fmt.Sprintf("Metric %s expects %d label values got %d", v.name, len(v.labels), len(values)))
+    }
+
+    key := strings.Join(values, "\xff")
+    v.Lock()
+    defer v.Unlock()
+
+    c, ok := v.children[key]
+    if !ok {
+        c = create()
+        v.children[key] = c
+        v.keys[key] = append([]string(nil), values...)
+    }
+
+    return c
+}
+
+// sorted returns children ordered by label values for stable output
+func (v *vec) sorted() ([]interface{}, [][]string) {
+    v.Lock()
+    defer v.Unlock()
+
+    keys := make([]string, 0, len(v.children))
+    for k := range v.children {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    children := make([]interface{}, len(keys))
+    values := make([][]string, len(keys))
+    for i, k := range keys {
+        children[i] = v.children[k]
+        values[i] = v.keys[k]
+    }
+
+    return children, values
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+    pairs := make([]string, 0, len(names)+1)
+    for i, n := range names {
+        pairs = append(pairs, n+"=\""+escapeLabel(values[i])+"\"")
+    }
+
+    for i := 0; i+1 < len(extra); i += 2 {
+        pairs = append(pairs, extra[i]+"=\""+escapeLabel(extra[i+1])+"\"")
+    }
+
+    if len(pairs) == 0 {
+        return ""
+    }
+
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+    v = strings.Replace(v, "\\", "\\\\", -1)
+    v = strings.Replace(v, "\"", "\\\"", -1)
+    return strings.Replace(v, "\n", "\\n", -1)
+}
+
+func formatFloat(f float64) string {
+    if math.IsInf(f, 1) {
+        return "+Inf"
+    }
+
+    return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter only goes up
+type Counter struct {
+    sync.Mutex
+    value float64
+}
+
+func (c *Counter) Add(v float64) {
+    c.Lock()
+    c.value += v
+    c.Unlock()
+}
+
+func (c *Counter) Inc() {
+    c.Add(1)
+}
+
+func (c *Counter) get() float64 {
+    c.Lock()
+    defer c.Unlock()
+    return c.value
+}
+
+type CounterVec struct {
+    *vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+    c := &CounterVec{newVec(name, help, "counter", labels)}
+    pDefaultRegistry.register(name, c)
+    return c
+}
+
+// With returns the counter for the given label values
+func (c *CounterVec) With(values ...string) *Counter {
+    return c.child(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+    c.writeHeader(w)
+    children, values := c.sorted()
+    for i, child := range children {
+        fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values[i]), formatFloat(child.(*Counter).get()))
+    }
+}
+
+// Gauge can go up and down
+type Gauge struct {
+    Counter
+}
+
+func (g *Gauge) Set(v float64) {
+    g.Lock()
+    g.value = v
+    g.Unlock()
+}
+
+func (g *Gauge) Dec() {
+    g.Add(-1)
+}
+
+type GaugeVec struct {
+    *vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+    g := &GaugeVec{newVec(name, help, "gauge", labels)}
+    pDefaultRegistry.register(name, g)
+    return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+    return g.child(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+    g.writeHeader(w)
+    children, values := g.sorted()
+    for i, child := range children {
+        fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, values[i]), formatFloat(child.(*Gauge).get()))
+    }
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+    sync.Mutex
+    buckets []float64
+    counts  []uint64
+    count   uint64
+    sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+    h.Lock()
+    defer h.Unlock()
+
+    for i, b := range h.buckets {
+        if v <= b {
+            h.counts[i]++
+        }
+    }
+    h.count++
+    h.sum += v
+}
+
+// ObserveSince records the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time) {
+    h.Observe(time.Since(start).Seconds())
+}
+
+type HistogramVec struct {
+    *vec
+    buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+    h := &HistogramVec{newVec(name, help, "histogram", labels), buckets}
+    pDefaultRegistry.register(name, h)
+    return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+    return h.child(values, func() interface{} {
+        return &Histogram{
+            buckets: h.buckets,
+            counts:  make([]uint64, len(h.buckets)),
+        }
+    }).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+    h.writeHeader(w)
+    children, values := h.sorted()
+    for i, child := range children {
+        hist := child.(*Histogram)
+        hist.Lock()
+        for j, b := range hist.buckets {
+            fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values[i], "le", formatFloat(b)), hist.counts[j])
+        }
+        fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values[i], "le", "+Inf"), hist.count)
+        fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values[i]), formatFloat(hist.sum))
+        fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values[i]), hist.count)
+        hist.Unlock()
+    }
+}
@@ -0,0 +1,257 @@
+package raspush
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    cFCMEndpoint = "https://fcm.googleapis.com/v1/projects/{project_id}/messages:send"
+    cFCMTokenURI = "https://oauth2.googleapis.com/token"
+    cFCMScope    = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+type fcmConfig struct {
+    ServiceAccountFile string `json:"service_account_file,omitempty"`
+    ProjectID          string `json:"project_id,omitempty"`
+    ClientEmail        string `json:"client_email,omitempty"`
+    PrivateKey         string `json:"private_key,omitempty"`
+    TokenURI           string `json:"token_uri,omitempty"`
+    Endpoint           string `json:"endpoint,omitempty"`
+}
+
+type fcmProvider struct {
+    sync.Mutex
+    config      *fcmConfig
+    signingKey  *rsa.PrivateKey
+    client      *http.Client
+    accessToken string
+    tokenExpiry time.Time
+}
+
+// NewFCMProvider creates push provider for Firebase Cloud Messaging HTTP v1
+func NewFCMProvider() PushProvider {
+    return &fcmProvider{
+        client: newHTTPClient(),
+    }
+}
+
+func (f *fcmProvider) Platform() string {
+    return PlatformFCM
+}
+
+func (f *fcmProvider) Init(cfg map[string]string) error {
+    fcfg, err := loadFCMConfig(cfg)
+    if err != nil {
+        return err
+    }
+
+    block, _ := pem.Decode([]byte(fcfg.PrivateKey))
+    if block == nil {
+        return errors.New("Invalid FCM private key")
+    }
+
+    key, err := parseRSAPrivateKey(block.Bytes)
+    if err != nil {
+        return err
+    }
+
+    f.config = fcfg
+    f.signingKey = key
+    return nil
+}
+
+func loadFCMConfig(cfg map[string]string) (*fcmConfig, error) {
+    jsonBytes, err := json.Marshal(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    overrides := &fcmConfig{}
+    if err = json.Unmarshal(jsonBytes, overrides); err != nil {
+        return nil, err
+    }
+
+    // Values from the config section win over the service account file
+    cObj := &fcmConfig{}
+    if overrides.ServiceAccountFile != "" {
+        content, err := ioutil.ReadFile(overrides.ServiceAccountFile)
+        if err != nil {
+            return nil, err
+        }
+
+        if err = json.Unmarshal(content, cObj); err != nil {
+            return nil, err
+        }
+    }
+
+    if err = json.Unmarshal(jsonBytes, cObj); err != nil {
+        return nil, err
+    }
+
+    if cObj.TokenURI == "" {
+        cObj.TokenURI = cFCMTokenURI
+    }
+
+    if cObj.Endpoint == "" {
+        cObj.Endpoint = cFCMEndpoint
+    }
+    cObj.Endpoint = strings.Replace(cObj.Endpoint, "{project_id}", cObj.ProjectID, -1)
+
+    if cObj.ProjectID == "" || cObj.ClientEmail == "" || cObj.PrivateKey == "" {
+        return nil, errors.New("FCM requires project_id, client_email and private_key")
+    }
+
+    return cObj, nil
+}
+
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+    if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+        return key, nil
+    }
+
+    parsed, err := x509.ParsePKCS8PrivateKey(der)
+    if err != nil {
+        return nil, err
+    }
+
+    key, ok := parsed.(*rsa.PrivateKey)
+    if !ok {
+        return nil, errors.New("FCM private key is not an RSA key")
+    }
+
+    return key, nil
+}
+
+func (f *fcmProvider) Send(sub *Subscription, payload []byte) error {
+    token, err := f.getAccessToken()
+    if err != nil {
+        return &retryableError{err: err}
+    }
+
+    body, err := json.Marshal(map[string]interface{}{
+        "message": map[string]interface{}{
+            "token": sub.Token,
+            "data": map[string]string{
+                "json_msg": string(payload),
+            },
+            "android": map[string]string{
+                "ttl": fmt.Sprintf("%ds", cDefaultTTL),
+            },
+        },
+    })
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", f.config.Endpoint, bytes.NewBuffer(body))
+    if err != nil {
+        return err
+    }
+
+    req.Header.Add("Authorization", "Bearer "+token)
+    req.Header.Add("Content-Type", "application/json")
+
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return &retryableError{err: err}
+    }
+    defer resp.Body.Close()
+
+    respBody, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode == http.StatusUnauthorized {
+        f.invalidateAccessToken()
+        return &retryableError{err: fmt.Errorf("FCM rejected access token %s", respBody)}
+    }
+
+    // FCM reports stale tokens as 404 UNREGISTERED or 400 INVALID_ARGUMENT on the token field
+    if resp.StatusCode == http.StatusBadRequest && bytes.Contains(respBody, []byte("registration token")) {
+        return ErrInvalidToken
+    }
+
+    return classifyResponse(resp, respBody)
+}
+
+func (f *fcmProvider) getAccessToken() (string, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    if f.accessToken != "" && time.Now().Before(f.tokenExpiry) {
+        return f.accessToken, nil
+    }
+
+    assertion, err := f.signAssertion()
+    if err != nil {
+        return "", err
+    }
+
+    resp, err := f.client.PostForm(f.config.TokenURI, url.Values{
+        "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+        "assertion":  {assertion},
+    })
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return "", fmt.Errorf("Unable to fetch FCM access token %s %s", resp.Status, body)
+    }
+
+    tokenResp := &struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int64  `json:"expires_in"`
+    }{}
+    if err = json.NewDecoder(resp.Body).Decode(tokenResp); err != nil {
+        return "", err
+    }
+
+    // Refresh a minute early so in-flight requests don't race expiry
+    f.accessToken = tokenResp.AccessToken
+    f.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn)*time.Second - time.Minute)
+    return f.accessToken, nil
+}
+
+func (f *fcmProvider) invalidateAccessToken() {
+    f.Lock()
+    defer f.Unlock()
+
+    f.accessToken = ""
+}
+
+func (f *fcmProvider) signAssertion() (string, error) {
+    now := time.Now().Unix()
+    signingInput, err := encodeJWTSigningInput("RS256", map[string]interface{}{
+        "iss":   f.config.ClientEmail,
+        "scope": cFCMScope,
+        "aud":   f.config.TokenURI,
+        "iat":   now,
+        "exp":   now + 3600,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    digest := sha256.Sum256([]byte(signingInput))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, f.signingKey, crypto.SHA256, digest[:])
+    if err != nil {
+        return "", err
+    }
+
+    return signingInput + "." + base64URL(sig), nil
+}
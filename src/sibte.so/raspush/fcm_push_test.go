@@ -0,0 +1,164 @@
+package raspush
+
+import (
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+)
+
+// fcmStub serves the OAuth token exchange and the v1 send endpoint
+type fcmStub struct {
+    t           *testing.T
+    key         *rsa.PublicKey
+    server      *httptest.Server
+    tokenFetch  int32
+    sendStatus  int
+    sentToken   string
+    sentPayload string
+}
+
+func newFCMStub(t *testing.T, key *rsa.PublicKey) *fcmStub {
+    s := &fcmStub{t: t, key: key, sendStatus: http.StatusOK}
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/token", s.onToken)
+    mux.HandleFunc("/v1/projects/raspchat/messages:send", s.onSend)
+    s.server = httptest.NewServer(mux)
+    return s
+}
+
+func (s *fcmStub) onToken(w http.ResponseWriter, req *http.Request) {
+    atomic.AddInt32(&s.tokenFetch, 1)
+
+    if req.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+        s.t.Errorf("Unexpected grant_type %q", req.FormValue("grant_type"))
+    }
+
+    parts := strings.Split(req.FormValue("assertion"), ".")
+    if len(parts) != 3 {
+        http.Error(w, "malformed assertion", http.StatusBadRequest)
+        return
+    }
+
+    header := map[string]string{}
+    headerJSON, _ := decodeBase64URL(parts[0])
+    if json.Unmarshal(headerJSON, &header) != nil || header["alg"] != "RS256" {
+        s.t.Errorf("Unexpected JWT header %s", headerJSON)
+    }
+
+    sig, _ := decodeBase64URL(parts[2])
+    digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    if err := rsa.VerifyPKCS1v15(s.key, crypto.SHA256, digest[:], sig); err != nil {
+        s.t.Error("Assertion signature doesn't verify:", err)
+        http.Error(w, "bad signature", http.StatusUnauthorized)
+        return
+    }
+
+    claims := map[string]interface{}{}
+    claimsJSON, _ := decodeBase64URL(parts[1])
+    json.Unmarshal(claimsJSON, &claims)
+    if claims["iss"] != "push@raspchat.iam.gserviceaccount.com" || claims["scope"] != cFCMScope || claims["aud"] != s.server.URL+"/token" {
+        s.t.Errorf("Unexpected claims %s", claimsJSON)
+    }
+
+    iat, _ := claims["iat"].(float64)
+    exp, _ := claims["exp"].(float64)
+    if exp-iat != 3600 {
+        s.t.Errorf("Assertion valid for %vs", exp-iat)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "access_token": "stub-access-token",
+        "expires_in":   3600,
+    })
+}
+
+func (s *fcmStub) onSend(w http.ResponseWriter, req *http.Request) {
+    if req.Header.Get("Authorization") != "Bearer stub-access-token" {
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    body := &struct {
+        Message struct {
+            Token string            `json:"token"`
+            Data  map[string]string `json:"data"`
+        } `json:"message"`
+    }{}
+    json.NewDecoder(req.Body).Decode(body)
+    s.sentToken = body.Message.Token
+    s.sentPayload = body.Message.Data["json_msg"]
+
+    if s.sendStatus != http.StatusOK {
+        w.WriteHeader(s.sendStatus)
+        w.Write([]byte(`{"error": {"status": "UNREGISTERED"}}`))
+        return
+    }
+
+    w.Write([]byte(`{"name": "projects/raspchat/messages/1"}`))
+}
+
+func newTestFCMProvider(t *testing.T) (*fcmProvider, *fcmStub) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    stub := newFCMStub(t, &key.PublicKey)
+    keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+    f := NewFCMProvider().(*fcmProvider)
+    err = f.Init(map[string]string{
+        "project_id":   "raspchat",
+        "client_email": "push@raspchat.iam.gserviceaccount.com",
+        "private_key":  string(keyPEM),
+        "token_uri":    stub.server.URL + "/token",
+        "endpoint":     stub.server.URL + "/v1/projects/{project_id}/messages:send",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return f, stub
+}
+
+func TestFCMSendExchangesTokenOnce(t *testing.T) {
+    f, stub := newTestFCMProvider(t)
+    defer stub.server.Close()
+
+    sub := &Subscription{Platform: PlatformFCM, Token: "device-token"}
+    for i := 0; i < 2; i++ {
+        if err := f.Send(sub, []byte(`{"msg":"hi"}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if stub.sentToken != "device-token" || stub.sentPayload != `{"msg":"hi"}` {
+        t.Fatalf("Stub got token %q payload %q", stub.sentToken, stub.sentPayload)
+    }
+
+    // The access token is cached until it expires
+    if n := atomic.LoadInt32(&stub.tokenFetch); n != 1 {
+        t.Fatalf("Access token fetched %d times", n)
+    }
+}
+
+func TestFCMUnregisteredIsInvalidToken(t *testing.T) {
+    f, stub := newTestFCMProvider(t)
+    defer stub.server.Close()
+    stub.sendStatus = http.StatusNotFound
+
+    if err := f.Send(&Subscription{Platform: PlatformFCM, Token: "stale"}, []byte("{}")); err != ErrInvalidToken {
+        t.Fatalf("Expected ErrInvalidToken, got %v", err)
+    }
+}
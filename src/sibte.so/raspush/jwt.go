@@ -0,0 +1,37 @@
+package raspush
+
+import (
+    "encoding/base64"
+    "encoding/json"
+)
+
+func base64URL(b []byte) string {
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeBase64URL accepts both padded and unpadded url-safe base64
+func decodeBase64URL(s string) ([]byte, error) {
+    if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+        return b, nil
+    }
+
+    return base64.URLEncoding.DecodeString(s)
+}
+
+// encodeJWTSigningInput returns "<header>.<claims>" ready to be signed
+func encodeJWTSigningInput(alg string, claims map[string]interface{}) (string, error) {
+    header, err := json.Marshal(map[string]string{
+        "typ": "JWT",
+        "alg": alg,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    body, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+
+    return base64URL(header) + "." + base64URL(body), nil
+}
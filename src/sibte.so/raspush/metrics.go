@@ -0,0 +1,12 @@
+package raspush
+
+import (
+    "sibte.so/rasmetrics"
+)
+
+var (
+    pDeliveries = rasmetrics.NewCounterVec(
+        "raspush_deliveries_total", "Push deliveries by platform and outcome", "platform", "outcome")
+    pRetries = rasmetrics.NewCounterVec(
+        "raspush_retries_total", "Push delivery attempts retried after a transient failure", "platform")
+)
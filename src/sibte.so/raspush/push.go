@@ -0,0 +1,184 @@
+package raspush
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// ErrInvalidToken is returned when the push service reports that a device
+// token (or web push endpoint) is no longer valid and should be unregistered
+var ErrInvalidToken = errors.New("Push token is no longer valid")
+
+const (
+    PlatformFCM     = "fcm"
+    PlatformWebPush = "webpush"
+)
+
+const (
+    cDefaultTTL        = 3 * 60 * 60
+    cMaxSendAttempts   = 4
+    cInitialBackoff    = 500 * time.Millisecond
+    cMaxBackoff        = 30 * time.Second
+    cHTTPClientTimeout = 30 * time.Second
+)
+
+// Subscription identifies a single device registered for push notifications
+type Subscription struct {
+    Platform string `json:"platform"`
+    Token    string `json:"token"`
+    P256dh   string `json:"p256dh,omitempty"`
+    Auth     string `json:"auth,omitempty"`
+}
+
+// PushProvider interface defining a push notification service
+type PushProvider interface {
+    Init(map[string]string) error
+    Platform() string
+    Send(*Subscription, []byte) error
+}
+
+// retryableError marks failures worth retrying (throttling, 5xx, network)
+type retryableError struct {
+    err        error
+    retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+    return e.err.Error()
+}
+
+// Sender dispatches payloads to the provider matching a subscription
+// platform, retrying transient failures with exponential backoff
+type Sender struct {
+    providers      map[string]PushProvider
+    OnInvalidToken func(*Subscription)
+}
+
+// NewSender initializes every provider that has a configuration section
+func NewSender(configs map[string]map[string]string) (*Sender, error) {
+    s := &Sender{
+        providers: make(map[string]PushProvider),
+    }
+
+    for _, p := range []PushProvider{NewFCMProvider(), NewWebPushProvider()} {
+        cfg, ok := configs[p.Platform()]
+        if !ok {
+            continue
+        }
+
+        if err := p.Init(cfg); err != nil {
+            return nil, fmt.Errorf("Unable to initialize %s push provider: %v", p.Platform(), err)
+        }
+
+        s.providers[p.Platform()] = p
+    }
+
+    if len(s.providers) == 0 {
+        return nil, errors.New("No push provider configured")
+    }
+
+    return s, nil
+}
+
+// Provider returns the provider registered for platform
+func (s *Sender) Provider(platform string) (PushProvider, bool) {
+    p, ok := s.providers[platform]
+    return p, ok
+}
+
+// Send delivers payload to sub, returns ErrInvalidToken after notifying
+// OnInvalidToken if the push service rejected the subscription
+func (s *Sender) Send(sub *Subscription, payload []byte) error {
+    p, ok := s.providers[sub.Platform]
+    if !ok {
+        return fmt.Errorf("No push provider for platform %s", sub.Platform)
+    }
+
+    backoff := cInitialBackoff
+    var err error
+    for attempt := 1; attempt <= cMaxSendAttempts; attempt++ {
+        err = p.Send(sub, payload)
+        if err == nil {
+            pDeliveries.With(sub.Platform, "success").Inc()
+            return nil
+        }
+
+        if err == ErrInvalidToken {
+            pDeliveries.With(sub.Platform, "invalid_token").Inc()
+            if s.OnInvalidToken != nil {
+                s.OnInvalidToken(sub)
+            }
+            return err
+        }
+
+        rErr, retryable := err.(*retryableError)
+        if !retryable || attempt == cMaxSendAttempts {
+            break
+        }
+
+        wait := retryWait(backoff, rErr)
+        pRetries.With(sub.Platform).Inc()
+        log.Println("Push delivery failed, retrying in", wait, err)
+        time.Sleep(wait)
+        if backoff *= 2; backoff > cMaxBackoff {
+            backoff = cMaxBackoff
+        }
+    }
+
+    pDeliveries.With(sub.Platform, "failed").Inc()
+    return err
+}
+
+// retryWait honours the service's Retry-After when it asks for more than
+// the backoff, but never waits longer than cMaxBackoff
+func retryWait(backoff time.Duration, err *retryableError) time.Duration {
+    wait := backoff
+    if err.retryAfter > wait {
+        wait = err.retryAfter
+    }
+
+    if wait > cMaxBackoff {
+        wait = cMaxBackoff
+    }
+
+    return wait
+}
+
+// classifyResponse maps a push service HTTP status to a send result
+func classifyResponse(resp *http.Response, body []byte) error {
+    switch {
+    case resp.StatusCode >= 200 && resp.StatusCode < 300:
+        return nil
+    case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+        return ErrInvalidToken
+    case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+        return &retryableError{
+            err:        fmt.Errorf("Push service responded %s %s", resp.Status, body),
+            retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+        }
+    }
+
+    return fmt.Errorf("Push service responded %s %s", resp.Status, body)
+}
+
+func parseRetryAfter(v string) time.Duration {
+    if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+        return time.Duration(secs) * time.Second
+    }
+
+    if t, err := http.ParseTime(v); err == nil {
+        return t.Sub(time.Now())
+    }
+
+    return 0
+}
+
+func newHTTPClient() *http.Client {
+    return &http.Client{
+        Timeout: cHTTPClientTimeout,
+    }
+}
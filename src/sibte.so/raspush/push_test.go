@@ -0,0 +1,27 @@
+package raspush
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestRetryWaitHonoursRetryAfterUpToMaxBackoff(t *testing.T) {
+    cases := []struct {
+        backoff    time.Duration
+        retryAfter time.Duration
+        expected   time.Duration
+    }{
+        {cInitialBackoff, 0, cInitialBackoff},
+        {cInitialBackoff, 5 * time.Second, 5 * time.Second},
+        {cInitialBackoff, time.Hour, cMaxBackoff},
+        {cMaxBackoff, 0, cMaxBackoff},
+    }
+
+    for _, c := range cases {
+        err := &retryableError{err: errors.New("throttled"), retryAfter: c.retryAfter}
+        if wait := retryWait(c.backoff, err); wait != c.expected {
+            t.Errorf("Backoff %v with Retry-After %v waited %v, expected %v", c.backoff, c.retryAfter, wait, c.expected)
+        }
+    }
+}
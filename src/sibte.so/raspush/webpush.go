@@ -0,0 +1,326 @@
+package raspush
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+)
+
+const (
+    cWebPushRecordSize = 4096
+    // record size - header (86) - padding delimiter (1) - AEAD tag (16)
+    cWebPushMaxPayload = cWebPushRecordSize - 86 - 1 - 16
+)
+
+type webPushConfig struct {
+    VAPIDPrivateKey string `json:"vapid_private_key,omitempty"`
+    Subject         string `json:"subject,omitempty"`
+}
+
+// errBlockedAddress is returned when an endpoint resolves to an address the
+// server must not send requests to
+var errBlockedAddress = errors.New("Web push endpoint address is not public")
+
+// cgnatNet is the shared address space of RFC 6598, not covered by IsPrivate
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+type webPushProvider struct {
+    config        *webPushConfig
+    vapidKey      *ecdsa.PrivateKey
+    vapidPubK     string
+    client        *http.Client
+    checkEndpoint func(string) error
+}
+
+// NewWebPushProvider creates push provider for standard Web Push (RFC 8030)
+// with VAPID authentication (RFC 8292) and aes128gcm encryption (RFC 8291)
+func NewWebPushProvider() PushProvider {
+    return &webPushProvider{
+        client:        newWebPushHTTPClient(),
+        checkEndpoint: ValidateEndpoint,
+    }
+}
+
+// newWebPushHTTPClient creates a client that only connects to public
+// addresses, endpoints come from clients and must not reach internal hosts.
+// The check runs on the resolved address so DNS can't be used to get around it
+func newWebPushHTTPClient() *http.Client {
+    dialer := &net.Dialer{
+        Timeout: cHTTPClientTimeout,
+        Control: func(network, address string, _ syscall.RawConn) error {
+            host, _, err := net.SplitHostPort(address)
+            if err != nil {
+                return err
+            }
+
+            if !isPublicIP(net.ParseIP(host)) {
+                return errBlockedAddress
+            }
+
+            return nil
+        },
+    }
+
+    return &http.Client{
+        Timeout: cHTTPClientTimeout,
+        Transport: &http.Transport{
+            // No proxy, the address check has to see the push service itself
+            Proxy:               nil,
+            DialContext:         dialer.DialContext,
+            TLSHandshakeTimeout: 10 * time.Second,
+            MaxIdleConns:        16,
+            IdleConnTimeout:     90 * time.Second,
+        },
+    }
+}
+
+func isPublicIP(ip net.IP) bool {
+    return ip != nil &&
+        !ip.IsLoopback() &&
+        !ip.IsPrivate() &&
+        !ip.IsLinkLocalUnicast() &&
+        !ip.IsLinkLocalMulticast() &&
+        !ip.IsInterfaceLocalMulticast() &&
+        !ip.IsMulticast() &&
+        !ip.IsUnspecified() &&
+        !cgnatNet.Contains(ip)
+}
+
+// ValidateEndpoint checks that a web push endpoint is an https URL that
+// doesn't name a loopback, private or link-local host. Host names are
+// checked again against the addresses they resolve to when sending
+func ValidateEndpoint(endpoint string) error {
+    u, err := url.Parse(endpoint)
+    if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+        return ErrInvalidToken
+    }
+
+    host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+    if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+        return ErrInvalidToken
+    }
+
+    if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+        return ErrInvalidToken
+    }
+
+    return nil
+}
+
+func (w *webPushProvider) Platform() string {
+    return PlatformWebPush
+}
+
+func (w *webPushProvider) Init(cfg map[string]string) error {
+    jsonBytes, err := json.Marshal(cfg)
+    if err != nil {
+        return err
+    }
+
+    wcfg := &webPushConfig{}
+    if err = json.Unmarshal(jsonBytes, wcfg); err != nil {
+        return err
+    }
+
+    if wcfg.VAPIDPrivateKey == "" || wcfg.Subject == "" {
+        return errors.New("Web push requires vapid_private_key and subject")
+    }
+
+    d, err := decodeBase64URL(wcfg.VAPIDPrivateKey)
+    if err != nil || len(d) != 32 {
+        return errors.New("vapid_private_key must be a base64url encoded P-256 scalar")
+    }
+
+    ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+    if err != nil {
+        return errors.New("vapid_private_key is not a valid P-256 scalar")
+    }
+
+    // Uncompressed point 0x04 || X || Y
+    public := ecdhKey.PublicKey().Bytes()
+    key := &ecdsa.PrivateKey{
+        PublicKey: ecdsa.PublicKey{
+            Curve: elliptic.P256(),
+            X:     new(big.Int).SetBytes(public[1:33]),
+            Y:     new(big.Int).SetBytes(public[33:]),
+        },
+        D: new(big.Int).SetBytes(d),
+    }
+
+    w.config = wcfg
+    w.vapidKey = key
+    w.vapidPubK = base64URL(public)
+    return nil
+}
+
+// PublicKey returns the VAPID application server key browsers subscribe with
+func (w *webPushProvider) PublicKey() string {
+    return w.vapidPubK
+}
+
+func (w *webPushProvider) Send(sub *Subscription, payload []byte) error {
+    // Subscriptions saved before endpoints were validated are dropped here
+    if err := w.checkEndpoint(sub.Token); err != nil {
+        return ErrInvalidToken
+    }
+
+    endpoint, err := url.Parse(sub.Token)
+    if err != nil {
+        return ErrInvalidToken
+    }
+
+    body, err := encryptWebPushPayload(sub, payload)
+    if err != nil {
+        return err
+    }
+
+    authHeader, err := w.vapidAuthorization(endpoint.Scheme + "://" + endpoint.Host)
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", sub.Token, bytes.NewBuffer(body))
+    if err != nil {
+        return err
+    }
+
+    req.Header.Add("Authorization", authHeader)
+    req.Header.Add("Content-Encoding", "aes128gcm")
+    req.Header.Add("Content-Type", "application/octet-stream")
+    req.Header.Add("TTL", strconv.Itoa(cDefaultTTL))
+    req.Header.Add("Urgency", "normal")
+
+    resp, err := w.client.Do(req)
+    if errors.Is(err, errBlockedAddress) {
+        return ErrInvalidToken
+    } else if err != nil {
+        return &retryableError{err: err}
+    }
+    defer resp.Body.Close()
+
+    respBody, _ := ioutil.ReadAll(resp.Body)
+    return classifyResponse(resp, respBody)
+}
+
+func (w *webPushProvider) vapidAuthorization(audience string) (string, error) {
+    signingInput, err := encodeJWTSigningInput("ES256", map[string]interface{}{
+        "aud": audience,
+        "exp": time.Now().Add(12 * time.Hour).Unix(),
+        "sub": w.config.Subject,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    digest := sha256.Sum256([]byte(signingInput))
+    r, s, err := ecdsa.Sign(rand.Reader, w.vapidKey, digest[:])
+    if err != nil {
+        return "", err
+    }
+
+    // JWS ES256 signatures are the fixed width concatenation r || s
+    sig := make([]byte, 64)
+    rBytes, sBytes := r.Bytes(), s.Bytes()
+    copy(sig[32-len(rBytes):32], rBytes)
+    copy(sig[64-len(sBytes):], sBytes)
+
+    return fmt.Sprintf("vapid t=%s.%s, k=%s", signingInput, base64URL(sig), w.vapidPubK), nil
+}
+
+// encryptWebPushPayload encrypts payload as a single aes128gcm record
+func encryptWebPushPayload(sub *Subscription, payload []byte) ([]byte, error) {
+    if len(payload) > cWebPushMaxPayload {
+        return nil, fmt.Errorf("Web push payload too large %d bytes", len(payload))
+    }
+
+    uaPublic, err := decodeBase64URL(sub.P256dh)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+
+    authSecret, err := decodeBase64URL(sub.Auth)
+    if err != nil || len(authSecret) == 0 {
+        return nil, ErrInvalidToken
+    }
+
+    uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+
+    asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    asPublic := asPrivate.PublicKey().Bytes()
+
+    ecdhSecret, err := asPrivate.ECDH(uaKey)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+
+    salt := make([]byte, 16)
+    if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+        return nil, err
+    }
+
+    // RFC 8291 section 3.4
+    keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+    keyInfo = append(keyInfo, asPublic...)
+    ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+    cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+    nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+    block, err := aes.NewCipher(cek)
+    if err != nil {
+        return nil, err
+    }
+
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    // 0x02 marks the last (and only) record
+    plaintext := append(append([]byte(nil), payload...), 0x02)
+
+    header := bytes.NewBuffer(make([]byte, 0, 86+len(plaintext)+gcm.Overhead()))
+    header.Write(salt)
+    binary.Write(header, binary.BigEndian, uint32(cWebPushRecordSize))
+    header.WriteByte(byte(len(asPublic)))
+    header.Write(asPublic)
+
+    return gcm.Seal(header.Bytes(), nonce, plaintext, nil), nil
+}
+
+// hkdf implements extract and a single expand block of RFC 5869 with SHA-256
+func hkdf(salt, ikm, info []byte, length int) []byte {
+    extract := hmac.New(sha256.New, salt)
+    extract.Write(ikm)
+    prk := extract.Sum(nil)
+
+    expand := hmac.New(sha256.New, prk)
+    expand.Write(info)
+    expand.Write([]byte{0x01})
+    return expand.Sum(nil)[:length]
+}
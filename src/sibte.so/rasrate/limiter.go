@@ -0,0 +1,136 @@
+package rasrate
+
+import (
+    "net"
+    "sync"
+    "time"
+)
+
+// Buckets untouched for this long are full again and get dropped
+const cSweepInterval = time.Minute
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// Limiter keeps one token bucket per key, each refilled at rate tokens per
+// second up to burst. A nil Limiter allows everything
+type Limiter struct {
+    sync.Mutex
+    rate      float64
+    burst     float64
+    buckets   map[string]*bucket
+    lastSweep time.Time
+}
+
+// NewLimiter returns nil (unlimited) when rate or burst is not positive
+func NewLimiter(rate float64, burst int) *Limiter {
+    if rate <= 0 || burst <= 0 {
+        return nil
+    }
+
+    return &Limiter{
+        rate:      rate,
+        burst:     float64(burst),
+        buckets:   make(map[string]*bucket),
+        lastSweep: time.Now(),
+    }
+}
+
+// Allow takes a token from key's bucket, returns false if it was empty
+func (l *Limiter) Allow(key string) bool {
+    if l == nil {
+        return true
+    }
+
+    l.Lock()
+    defer l.Unlock()
+
+    now := time.Now()
+    if now.Sub(l.lastSweep) > cSweepInterval {
+        l.sweep(now)
+    }
+
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{
+            tokens: l.burst,
+            last:   now,
+        }
+        l.buckets[key] = b
+    }
+
+    b.tokens += now.Sub(b.last).Seconds() * l.rate
+    if b.tokens > l.burst {
+        b.tokens = l.burst
+    }
+    b.last = now
+
+    if b.tokens < 1 {
+        return false
+    }
+
+    b.tokens--
+    return true
+}
+
+// Refund gives back a token taken from key's bucket, for callers that took
+// it but didn't go through with the action
+func (l *Limiter) Refund(key string) {
+    if l == nil {
+        return
+    }
+
+    l.Lock()
+    defer l.Unlock()
+
+    if b, ok := l.buckets[key]; ok && b.tokens+1 <= l.burst {
+        b.tokens++
+    }
+}
+
+// Carry makes the bucket of to no fuller than the bucket of from, so a key
+// that changes can't start over with a full bucket
+func (l *Limiter) Carry(from, to string) {
+    if l == nil || from == to {
+        return
+    }
+
+    l.Lock()
+    defer l.Unlock()
+
+    b, ok := l.buckets[from]
+    if !ok {
+        return
+    }
+
+    if existing, ok := l.buckets[to]; ok && existing.tokens <= b.tokens {
+        return
+    }
+
+    l.buckets[to] = &bucket{
+        tokens: b.tokens,
+        last:   b.last,
+    }
+}
+
+func (l *Limiter) sweep(now time.Time) {
+    l.lastSweep = now
+    for key, b := range l.buckets {
+        if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+// HostOf strips the port from a remote address so all connections from the
+// same IP share a bucket
+func HostOf(remoteAddr string) string {
+    host, _, err := net.SplitHostPort(remoteAddr)
+    if err != nil {
+        return remoteAddr
+    }
+
+    return host
+}
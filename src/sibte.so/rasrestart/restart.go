@@ -0,0 +1,154 @@
+package rasrestart
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+)
+
+const (
+    // Names of the inherited listeners, in file descriptor order starting at 3
+    cInheritedListenersEnv = "RASPCHAT_LISTENERS"
+
+    // File descriptor of the pipe the parent closes once the child may start
+    cParentReleaseEnv = "RASPCHAT_PARENT_RELEASE_FD"
+)
+
+var errNotTCPListener = errors.New("Only TCP listeners can be handed off")
+
+// Listen returns the listener named name inherited from a restarting parent
+// process, or a new TCP listener on addr
+func Listen(name, addr string) (net.Listener, error) {
+    for i, n := range strings.Split(os.Getenv(cInheritedListenersEnv), ",") {
+        if n != name {
+            continue
+        }
+
+        f := os.NewFile(uintptr(3+i), name)
+        if f == nil {
+            break
+        }
+        defer f.Close()
+
+        return net.FileListener(f)
+    }
+
+    return net.Listen("tcp", addr)
+}
+
+// WaitForParent blocks a process started by a hot restart until its parent
+// released the resources they share (like database locks). Connections
+// queue up on the inherited listeners meanwhile. It returns right away for a
+// process that wasn't started by a hot restart
+func WaitForParent() {
+    fd, err := strconv.Atoi(os.Getenv(cParentReleaseEnv))
+    if err != nil {
+        return
+    }
+
+    f := os.NewFile(uintptr(fd), "parent-release")
+    if f == nil {
+        return
+    }
+    defer f.Close()
+
+    // The parent never writes, the pipe reads EOF once it released or exited
+    io.Copy(ioutil.Discard, f)
+}
+
+// Handoff holds duplicates of listening sockets so they stay open (and keep
+// queueing connections) after the current process closes its listeners
+type Handoff struct {
+    names   []string
+    files   []*os.File
+    release *os.File
+}
+
+// Prepare duplicates every listener, must be called before they are closed
+func Prepare(listeners map[string]net.Listener) (*Handoff, error) {
+    h := &Handoff{}
+    for name, l := range listeners {
+        tcp, ok := l.(*net.TCPListener)
+        if !ok {
+            h.Close()
+            return nil, errNotTCPListener
+        }
+
+        f, err := tcp.File()
+        if err != nil {
+            h.Close()
+            return nil, err
+        }
+
+        h.names = append(h.names, name)
+        h.files = append(h.files, f)
+    }
+
+    return h, nil
+}
+
+// Start runs a fresh copy of the current executable with the same arguments
+// inheriting the prepared listeners. The child waits in WaitForParent until
+// Release (or Close) is called
+func (h *Handoff) Start() (*os.Process, error) {
+    path, err := os.Executable()
+    if err != nil {
+        return nil, err
+    }
+
+    wait, release, err := os.Pipe()
+    if err != nil {
+        return nil, err
+    }
+    defer wait.Close()
+
+    cmd := exec.Command(path, os.Args[1:]...)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    cmd.ExtraFiles = append(append([]*os.File{}, h.files...), wait)
+    cmd.Env = append(filterEnv(os.Environ()),
+        fmt.Sprintf("%s=%s", cInheritedListenersEnv, strings.Join(h.names, ",")),
+        fmt.Sprintf("%s=%d", cParentReleaseEnv, 3+len(h.files)))
+
+    if err := cmd.Start(); err != nil {
+        release.Close()
+        return nil, err
+    }
+
+    h.release = release
+    return cmd.Process, nil
+}
+
+// Release lets the started child go ahead, call it once this process closed
+// everything the child needs to open
+func (h *Handoff) Release() {
+    if h.release != nil {
+        h.release.Close()
+        h.release = nil
+    }
+}
+
+// Close releases the child and this process's copies of the sockets
+func (h *Handoff) Close() {
+    h.Release()
+    for _, f := range h.files {
+        f.Close()
+    }
+}
+
+func filterEnv(env []string) []string {
+    ret := make([]string, 0, len(env))
+    for _, e := range env {
+        if !strings.HasPrefix(e, cInheritedListenersEnv+"=") && !strings.HasPrefix(e, cParentReleaseEnv+"=") {
+            ret = append(ret, e)
+        }
+    }
+
+    return ret
+}
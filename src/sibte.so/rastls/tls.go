@@ -0,0 +1,172 @@
+package rastls
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// How often certificate files are checked for changes
+const cCertCheckInterval = 10 * time.Second
+
+var errNoClientCAs = errors.New("No certificates found in client CA file")
+
+var pMinVersions = map[string]uint16{
+    "":    tls.VersionTLS12,
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+// Options configure server side TLS
+type Options struct {
+    CertFile          string
+    KeyFile           string
+    MinVersion        string
+    ClientCAFile      string
+    RequireClientCert bool
+}
+
+// NewServerConfig returns a TLS config serving the certificate in opts, the
+// returned reloader must be closed to stop watching the files
+func NewServerConfig(opts Options) (*tls.Config, *CertReloader, error) {
+    minVersion, ok := pMinVersions[opts.MinVersion]
+    if !ok {
+        return nil, nil, fmt.Errorf("Unsupported TLS version %q", opts.MinVersion)
+    }
+
+    reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile, cCertCheckInterval)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    config := &tls.Config{
+        MinVersion:     minVersion,
+        GetCertificate: reloader.GetCertificate,
+    }
+
+    if opts.ClientCAFile != "" {
+        pem, err := ioutil.ReadFile(opts.ClientCAFile)
+        if err != nil {
+            reloader.Close()
+            return nil, nil, err
+        }
+
+        config.ClientCAs = x509.NewCertPool()
+        if !config.ClientCAs.AppendCertsFromPEM(pem) {
+            reloader.Close()
+            return nil, nil, errNoClientCAs
+        }
+
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+        if opts.RequireClientCert {
+            config.ClientAuth = tls.RequireAndVerifyClientCert
+        }
+    }
+
+    return config, reloader, nil
+}
+
+// CertReloader serves a certificate pair and loads it again whenever either
+// file changes, a broken pair keeps the previous certificate in use
+type CertReloader struct {
+    sync.RWMutex
+    certFile string
+    keyFile  string
+    cert     *tls.Certificate
+    modTime  time.Time
+    done     chan struct{}
+    once     sync.Once
+}
+
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+    r := &CertReloader{
+        certFile: certFile,
+        keyFile:  keyFile,
+        done:     make(chan struct{}),
+    }
+
+    if err := r.reload(r.lastModified()); err != nil {
+        return nil, err
+    }
+
+    go r.watch(interval)
+    return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    r.RLock()
+    defer r.RUnlock()
+
+    return r.cert, nil
+}
+
+func (r *CertReloader) Close() error {
+    r.once.Do(func() {
+        close(r.done)
+    })
+
+    return nil
+}
+
+func (r *CertReloader) watch(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-r.done:
+            return
+        }
+
+        modTime := r.lastModified()
+        r.RLock()
+        changed := modTime.After(r.modTime)
+        r.RUnlock()
+
+        if !changed {
+            continue
+        }
+
+        if err := r.reload(modTime); err != nil {
+            log.Println("Unable to reload certificate", r.certFile, err)
+            continue
+        }
+
+        log.Println("Reloaded certificate", r.certFile)
+    }
+}
+
+func (r *CertReloader) reload(modTime time.Time) error {
+    cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+    if err != nil {
+        return err
+    }
+
+    r.Lock()
+    defer r.Unlock()
+
+    r.cert = &cert
+    r.modTime = modTime
+    return nil
+}
+
+// lastModified is the newer modification time of the two files
+func (r *CertReloader) lastModified() time.Time {
+    var latest time.Time
+    for _, path := range []string{r.certFile, r.keyFile} {
+        if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+
+    return latest
+}
@@ -0,0 +1,191 @@
+package rasweb
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "sync"
+
+    "github.com/julienschmidt/httprouter"
+    "sibte.so/rasconfig"
+    "sibte.so/rasfs"
+    "sibte.so/rasmetrics"
+    "sibte.so/rasrate"
+)
+
+// MaxFileSizeLimit is 64 MB
+const MaxFileSizeLimit = 64 << 20
+
+var (
+    pUploadBytes = rasmetrics.NewCounterVec(
+        "rasweb_upload_bytes_total", "Bytes of successfully uploaded files").With()
+    pUploads = rasmetrics.NewCounterVec(
+        "rasweb_uploads_total", "File uploads by result", "result")
+)
+
+type fileUploadHandler struct {
+    sync.RWMutex
+    fsUploader    rasfs.RasFS
+    fsDownloader  rasfs.DownloadableRasFS
+    uploadLimiter *rasrate.Limiter
+}
+
+// NewFileUploadHandler handles file upload requests
+func NewFileUploadHandler() RouteHandler {
+    return &fileUploadHandler{}
+}
+
+func (p *fileUploadHandler) Register(r *httprouter.Router) error {
+    p.configure(rasconfig.Current())
+    rasconfig.OnReload(p.configure)
+
+    log.Println("Hooking files routes...")
+    r.POST("/file", p.upload)
+    r.PUT("/file", p.upload)
+    r.GET("/file/*downloadId", p.download)
+
+    return nil
+}
+
+// configure picks the first file system accepting the uploader config, it
+// runs again on every config reload
+func (p *fileUploadHandler) configure(conf *rasconfig.ApplicationConfig) {
+    configs := []rasfs.RasFS{
+        rasfs.NewAzureFS(),
+        rasfs.NewLocalFS(),
+    }
+
+    var uploader rasfs.RasFS
+    for _, fs := range configs {
+        err := fs.Init(conf.UploaderConfig)
+        if err == nil {
+            uploader = fs
+            break
+        }
+
+        log.Println("Error fs.Init", err)
+    }
+
+    downloader, ok := uploader.(rasfs.DownloadableRasFS)
+    if ok {
+        log.Println("Downloadable file upload handler detected...", downloader)
+    }
+
+    limit := conf.RateLimits[rasconfig.RateLimitUpload][rasconfig.RateScopeIP]
+
+    p.Lock()
+    defer p.Unlock()
+
+    p.fsUploader = uploader
+    p.fsDownloader = downloader
+    p.uploadLimiter = rasrate.NewLimiter(limit.Rate, limit.Burst)
+}
+
+func (p *fileUploadHandler) current() (rasfs.RasFS, rasfs.DownloadableRasFS, *rasrate.Limiter) {
+    p.RLock()
+    defer p.RUnlock()
+
+    return p.fsUploader, p.fsDownloader, p.uploadLimiter
+}
+
+func (p *fileUploadHandler) download(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+    _, fsDownloader, _ := p.current()
+    if fsDownloader == nil {
+        w.WriteHeader(422)
+        fmt.Fprintf(w, "Invalid uploader")
+        return
+    }
+
+    reader, err := fsDownloader.Download(params.ByName("downloadId"))
+    if err != nil {
+        w.WriteHeader(404)
+        fmt.Fprintf(w, "Unable to process request %v", err)
+        return
+    }
+    defer (func() {
+        reader.Close()
+    })()
+
+    io.Copy(w, reader)
+}
+
+func (p *fileUploadHandler) upload(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+    fsUploader, fsDownloader, uploadLimiter := p.current()
+    if fsUploader == nil {
+        w.WriteHeader(http.StatusServiceUnavailable)
+        fmt.Fprintf(w, "File uploads are not configured")
+        return
+    }
+
+    if !uploadLimiter.Allow(rasrate.HostOf(r.RemoteAddr)) {
+        pUploads.With("rate_limited").Inc()
+        w.WriteHeader(http.StatusTooManyRequests)
+        fmt.Fprintf(w, "Too many uploads, try again later")
+        return
+    }
+
+    // Defer printing error
+    var err error
+    defer func() {
+        if err == nil {
+            pUploads.With("success").Inc()
+            return
+        }
+        pUploads.With("error").Inc()
+        w.WriteHeader(500)
+        fmt.Fprintf(w, "Unable to process file upload error: %s", err.Error())
+    }()
+
+    if err = r.ParseMultipartForm(32 << 10); err != nil {
+        return
+    }
+
+    uploadedFile, handler, err := r.FormFile("file")
+    defer uploadedFile.Close()
+    if err != nil {
+        return
+    }
+
+    defer uploadedFile.Close()
+    fileSize, err := uploadedFile.Seek(0, os.SEEK_END)
+    if err != nil {
+        return
+    }
+
+    if fileSize > MaxFileSizeLimit {
+        err = errors.New("File size too long")
+        return
+    }
+
+    _, err = uploadedFile.Seek(0, os.SEEK_SET)
+    if err != nil {
+        return
+    }
+
+    url, err := fsUploader.Upload(handler.Filename, uint64(fileSize), uploadedFile)
+    if err != nil {
+        return
+    }
+    pUploadBytes.Add(float64(fileSize))
+
+    if fsDownloader != nil {
+        log.Println("Appending /file/ to", url)
+        url = "/file/" + url
+    }
+
+    response, err := json.Marshal(struct {
+        URL string `json:"url"`
+    }{
+        URL: url,
+    })
+
+    if err != nil {
+        return
+    }
+
+    w.Write(response)
+}
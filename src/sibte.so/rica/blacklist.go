@@ -0,0 +1,55 @@
+package rica
+
+import "sync"
+
+// BlackList holds banned user ids and IPs. Entries added at runtime and
+// the IPs listed in the configuration are kept apart so a config reload
+// doesn't lift manual bans
+type BlackList struct {
+    sync.RWMutex
+    banned     map[string]struct{}
+    configured map[string]struct{}
+}
+
+func NewBlackList() *BlackList {
+    return &BlackList{
+        banned:     make(map[string]struct{}),
+        configured: make(map[string]struct{}),
+    }
+}
+
+func (b *BlackList) Contains(key string) bool {
+    b.RLock()
+    defer b.RUnlock()
+
+    _, banned := b.banned[key]
+    _, configured := b.configured[key]
+    return banned || configured
+}
+
+func (b *BlackList) Add(key string) {
+    b.Lock()
+    defer b.Unlock()
+
+    b.banned[key] = struct{}{}
+}
+
+func (b *BlackList) Remove(key string) {
+    b.Lock()
+    defer b.Unlock()
+
+    delete(b.banned, key)
+}
+
+// SetConfigured replaces the banned IPs that come from the configuration
+func (b *BlackList) SetConfigured(ips []string) {
+    configured := make(map[string]struct{}, len(ips))
+    for _, ip := range ips {
+        configured[ip] = struct{}{}
+    }
+
+    b.Lock()
+    defer b.Unlock()
+
+    b.configured = configured
+}
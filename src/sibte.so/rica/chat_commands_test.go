@@ -0,0 +1,400 @@
+package rica_test
+
+import (
+    "strings"
+    "testing"
+
+    "sibte.so/rica"
+    "sibte.so/rica/consts"
+    "sibte.so/rica/ricatest"
+)
+
+func TestWelcomeAssignsNick(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    c := s.Connect("")
+    c.Expect(ricaEvents.FROM_SERVER)
+
+    nick := c.Nick()
+    if nick == "" {
+        t.Fatal("No nick assigned")
+    }
+
+    if _, ok := s.Nicks.IdOf(nick); !ok {
+        t.Error("Nick", nick, "not registered")
+    }
+}
+
+// A nick another node took over leaves the handler with its id as nick
+func TestLostNickFallsBackToId(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    id, _ := s.Nicks.IdOf("alice")
+
+    alice.Handler().NickLost("alice")
+    alice.Expect(ricaEvents.SET_NICK_REPLY, ricatest.Field("oldNick", "alice"), ricatest.Field("newNick", id))
+    if _, ok := s.Nicks.IdOf("alice"); ok {
+        t.Error("Lost nick still registered")
+    }
+}
+
+func TestSetNick(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+    bob.Join("lounge")
+
+    bob.SetNick("robert")
+    bob.Expect(ricaEvents.SET_NICK_REPLY, ricatest.Field("oldNick", "bob"), ricatest.Field("newNick", "robert"))
+
+    e := alice.Expect(ricaEvents.MEMBER_NICK_SET_REPLY, ricatest.To("lounge"))
+    if nick, _ := e.Fields["pack_msg"].(map[string]interface{}); nick["oldNick"] != "bob" || nick["newNick"] != "robert" {
+        t.Error("Unexpected nick change", e)
+    }
+
+    if _, ok := s.Nicks.IdOf("bob"); ok {
+        t.Error("Old nick still registered")
+    }
+}
+
+func TestTakenNickGetsSuffix(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    s.Connect("alice")
+    other := s.Connect("alice")
+    if other.Nick() != "alice_" {
+        t.Error("Expected alice_, got", other.Nick())
+    }
+}
+
+func TestInvalidNickIsRejected(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+    c.SetNick("not a nick!")
+    if c.Nick() != "alice" {
+        t.Error("Nick changed to", c.Nick())
+    }
+}
+
+func TestJoinIsAnnouncedToMembers(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    carol := s.Connect("carol")
+    alice.Join("lounge")
+    bob.Join("lounge")
+
+    alice.Expect(ricaEvents.JOIN_GROUP_REPLY, ricatest.From("bob"), ricatest.To("lounge"))
+    bob.Expect(ricaEvents.JOIN_GROUP_REPLY, ricatest.From("bob"), ricatest.To("lounge"))
+    bob.ExpectNo(ricaEvents.JOIN_GROUP_REPLY, ricatest.From("alice"))
+    carol.ExpectNo(ricaEvents.JOIN_GROUP_REPLY)
+}
+
+func TestGroupMessageFansOut(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    carol := s.Connect("carol")
+    alice.Join("lounge")
+    bob.Join("lounge")
+
+    bob.Say("lounge", "hello")
+    alice.Expect(ricaEvents.GROUP_MSG_REPLY, ricatest.From("bob"), ricatest.To("lounge"), ricatest.Message("hello"))
+    bob.Expect(ricaEvents.GROUP_MSG_REPLY, ricatest.From("bob"), ricatest.Message("hello"))
+    carol.ExpectNo(ricaEvents.GROUP_MSG_REPLY)
+
+    history := s.History("lounge")
+    if len(history) == 0 {
+        t.Fatal("Message not saved")
+    }
+
+    if msg, ok := history[0].(*rica.ChatMessage); !ok || msg.Message != "hello" || msg.From != "bob" {
+        t.Error("Unexpected latest message", history[0])
+    }
+}
+
+func TestMessagesNeedMembership(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+
+    bob.Say("lounge", "let me in")
+    alice.ExpectNo(ricaEvents.GROUP_MSG_REPLY)
+}
+
+func TestBlankAndOversizedMessagesAreDropped(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    alice.Join("lounge")
+
+    alice.Say("lounge", "   ")
+    alice.Say("lounge", strings.Repeat("a", 513))
+    alice.ExpectNo(ricaEvents.GROUP_MSG_REPLY)
+}
+
+func TestLeaveGroup(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+    bob.Join("lounge")
+
+    bob.Leave("lounge")
+    alice.Expect(ricaEvents.LEAVE_GROUP_REPLY, ricatest.From("bob"), ricatest.To("lounge"))
+
+    alice.Say("lounge", "still there?")
+    bob.ExpectNo(ricaEvents.GROUP_MSG_REPLY)
+
+    if e := alice.List("lounge"); !ricatest.Members("alice").Match(e) {
+        t.Error("Unexpected lounge members", e)
+    }
+}
+
+func TestListMembers(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+    bob.Join("lounge")
+    s.Connect("carol")
+
+    if e := alice.List("lounge"); !ricatest.Members("alice", "bob").Match(e) {
+        t.Error("Unexpected lounge members", e)
+    }
+
+    if e := alice.List(""); !ricatest.Members("alice", "bob", "carol").Match(e) {
+        t.Error("Unexpected server members", e)
+    }
+}
+
+func TestDirectMessage(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    carol := s.Connect("carol")
+
+    alice.Say("@bob", "psst")
+    bob.Expect(ricaEvents.DIRECT_MSG_REPLY, ricatest.From("alice"), ricatest.To("bob"), ricatest.Message("psst"))
+    alice.Expect(ricaEvents.DIRECT_MSG_REPLY, ricatest.From("alice"), ricatest.To("bob"))
+    carol.ExpectNo(ricaEvents.DIRECT_MSG_REPLY)
+}
+
+func TestMentionReachesUsersOutsideGroup(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+
+    alice.Say("lounge", "ping @bob")
+    bob.Expect(ricaEvents.MENTION_REPLY, ricatest.From("alice"), ricatest.To("lounge"), ricatest.Message("ping @bob"))
+    bob.ExpectNo(ricaEvents.GROUP_MSG_REPLY)
+    alice.ExpectNo(ricaEvents.MENTION_REPLY)
+}
+
+func TestSetStatus(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    alice.SetStatus(ricaEvents.STATUS_BUSY, "meeting")
+    alice.Expect(ricaEvents.PRESENCE_REPLY, ricatest.Field("status", ricaEvents.STATUS_BUSY), ricatest.Field("text", "meeting"))
+
+    alice.SetStatus("sleepy", "")
+    alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_INVALID_STATUS))
+}
+
+func TestResumeWithUnknownToken(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    alice.Command(ricaEvents.RESUME_COMMAND, map[string]interface{}{"msg": "bogus"})
+    alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_RESUME_FAILED))
+}
+
+func TestDisconnectLeavesGroups(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+    bob.Join("lounge")
+
+    bob.Hangup()
+    alice.Expect(ricaEvents.LEAVE_GROUP_REPLY, ricatest.From("bob"), ricatest.To("lounge"))
+    alice.Expect(ricaEvents.LEAVE_GROUP_REPLY, ricatest.From("bob"), ricatest.To(ricaEvents.FROM_SERVER))
+
+    if e := alice.List("lounge"); !ricatest.Members("alice").Match(e) {
+        t.Error("Unexpected lounge members", e)
+    }
+
+    if _, ok := s.Nicks.IdOf("bob"); ok {
+        t.Error("Nick still registered")
+    }
+
+    if bob.Transport().CloseCount() != 1 {
+        t.Error("Transport closed", bob.Transport().CloseCount(), "times")
+    }
+}
+
+func TestInvalidCommandsAreRejected(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    alice.Join("lounge")
+
+    alice.Send(`{"@": "no-such-command"}`)
+    alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_UNKNOWN_COMMAND), ricatest.Field("body", "no-such-command"))
+
+    alice.Send(`not json`)
+    alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_INVALID_MESSAGE))
+
+    alice.Send(`{"@": "join-group", "msg": 42}`)
+    alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_INVALID_MESSAGE), ricatest.Field("body", ricaEvents.JOIN_GROUP_COMMAND))
+
+    alice.Say("lounge", "still here")
+    alice.Expect(ricaEvents.GROUP_MSG_REPLY, ricatest.Message("still here"))
+}
+
+func TestJoinRefusesUnsafeGroupNames(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    for _, group := range []string{"", "two words", "a,b", "x\r\n:admin PRIVMSG bob :evil", "nul\x00", ricaEvents.FROM_SERVER} {
+        alice.Join(group)
+        alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_INVALID_GROUP), ricatest.Field("body", group))
+    }
+    alice.ExpectNo(ricaEvents.JOIN_GROUP_REPLY)
+
+    alice.Join("lounge")
+    alice.Expect(ricaEvents.JOIN_GROUP_REPLY, ricatest.To("lounge"))
+}
+
+func TestHello(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("")
+    alice.Hello(2, "reactions")
+
+    e := alice.Expect(ricaEvents.HELLO_REPLY, ricatest.Field("version", 2), ricatest.Field("min_version", 1))
+    if _, ok := e.Fields["server_time"].(float64); !ok {
+        t.Error("No server time in", e)
+    }
+
+    limits, _ := e.Fields["limits"].(map[string]interface{})
+    if limits["max_message_length"] != float64(512) || limits["max_nick_length"] != float64(42) {
+        t.Error("Unexpected limits", e)
+    }
+
+    if e.Field("api_token") == "" {
+        t.Error("No API token in", e)
+    }
+}
+
+func TestHelloFromNewerClient(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("")
+    alice.Hello(99)
+    alice.Expect(ricaEvents.HELLO_REPLY, ricatest.Field("version", 2))
+}
+
+func TestHelloSetsNickAndJoinsRooms(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    bob := s.Connect("bob")
+    bob.Join("lounge")
+
+    alice := s.Connect("")
+    alice.Command(ricaEvents.HELLO_COMMAND, map[string]interface{}{
+        "version": 2,
+        "nick":    "alice",
+        "rooms":   []string{"lounge", "random"},
+    })
+
+    alice.Expect(ricaEvents.HELLO_REPLY)
+    if alice.Nick() != "alice" {
+        t.Error("Nick is", alice.Nick())
+    }
+
+    bob.Expect(ricaEvents.JOIN_GROUP_REPLY, ricatest.From("alice"), ricatest.To("lounge"))
+    alice.Expect(ricaEvents.JOIN_GROUP_REPLY, ricatest.From("alice"), ricatest.To("random"))
+
+    // The server-hello comes before the nick it assigned
+    events, _ := alice.Transport().Events()
+    hello, nick := -1, -1
+    for i, e := range events {
+        if e.Name == ricaEvents.HELLO_REPLY {
+            hello = i
+        } else if e.Name == ricaEvents.SET_NICK_REPLY && e.Field("newNick") == "alice" {
+            nick = i
+        }
+    }
+
+    if hello < 0 || nick < hello {
+        t.Error("Expected server-hello before the nick change, got", events)
+    }
+}
+
+func TestHelloFeaturesGateOptionalEvents(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    carol := s.Connect("carol")
+    bob.Hello(2, ricaEvents.FEATURE_MENTIONS)
+    carol.Hello(2)
+
+    alice.Join("lounge")
+    alice.Say("lounge", "ping @bob @carol")
+    bob.Expect(ricaEvents.MENTION_REPLY, ricatest.From("alice"))
+    carol.ExpectNo(ricaEvents.MENTION_REPLY)
+}
+
+func TestOldProtocolVersionIsRejected(t *testing.T) {
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("")
+    alice.Send(`{"@": "hello", "version": 0}`)
+
+    e := alice.Expect(ricaEvents.ERROR_MSG_REPLY, ricatest.Field("error_type", ricaEvents.ERROR_UNSUPPORTED_VERSION))
+    if body, _ := e.Fields["body"].(map[string]interface{}); body["min_version"] != float64(1) {
+        t.Error("Error doesn't name the supported versions", e)
+    }
+
+    alice.WaitClosed()
+}
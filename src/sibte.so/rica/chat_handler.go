@@ -0,0 +1,939 @@
+package rica
+
+/*
+Copyright (c) 2015 Zohaib
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "math/rand"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "sibte.so/rasconfig"
+    "sibte.so/rasrate"
+    "sibte.so/rica/consts"
+
+    "github.com/speps/go-hashids"
+)
+
+// ChatHandler to handle chat connection
+type ChatHandler struct {
+    sync.Mutex
+    id               string
+    nick             string
+    apiToken         string
+    groupInfoManager GroupInfoManager
+    nickRegistry     *NickRegistry
+    transport        IMessageTransport
+    outgoingInfo     *userOutGoingInfo
+    groups           map[string]interface{}
+    blackList        *BlackList
+    chatStore        *ChatLogStore
+    pushNotifier     *PushNotifier
+    mentionStore     *MentionStore
+    floodControl     *FloodControl
+    flood            floodState
+    disconnecting    bool
+    writerDone       chan struct{}
+    nickLost         chan string
+    ctx              context.Context
+    cancel           context.CancelFunc
+    stopOnce         sync.Once
+    workers          sync.WaitGroup
+    done             chan struct{}
+    sessionStore     *SessionStore
+    cluster          *clusterNode
+    presence         *PresenceRegistry
+    presenceStore    *PresenceStore
+    status           Presence
+    announced        Presence
+    latency          time.Duration
+    extraFeatures    []string
+    protocolVersion  int
+    clientFeatures   []string
+}
+
+// Clients are pinged this often, their pongs measure the round trip
+const cPingInterval = 15 * time.Second
+
+// Mentions past this many known nicks in one message are ignored
+const cMaxMentionsPerMessage = 10
+
+var errBlackListed = errors.New("Connection is blacklisted")
+
+var pHashID = hashids.New()
+var pSnowFlake = DefaultSnowFlake()
+
+func messageOf(event string) BaseMessage {
+    return BaseMessage{
+        EventName: event,
+        Id:        pSnowFlake.Next(),
+    }
+}
+
+// NewChatHandler creates new ChatHandler
+func NewChatHandler(
+        nickReg *NickRegistry,
+        groupInfoMan GroupInfoManager,
+        trans IMessageTransport,
+        store *ChatLogStore,
+        ip string,
+        blackList *BlackList) *ChatHandler {
+    uid, _ := pHashID.Encode([]int{
+        int(rand.Int31n(1000)),
+        int(rand.Int31n(1000)),
+        int(rand.Int31n(1000)),
+    })
+
+    // Authenticates HTTP API calls made on behalf of this connection
+    apiToken, _ := newTransportSessionId()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    ret := &ChatHandler{
+        id:               uid,
+        nick:             uid,
+        apiToken:         apiToken,
+        nickRegistry:     nickReg,
+        groupInfoManager: groupInfoMan,
+        transport:        trans,
+        chatStore:        store,
+        blackList:        blackList,
+        outgoingInfo:     newUserOutGoingInfo(ip, cDefaultOutboundQueueSize, SlowClientDropOldest),
+        groups:           make(map[string]interface{}, 0),
+        writerDone:       make(chan struct{}),
+        nickLost:         make(chan string, 1),
+        ctx:              ctx,
+        cancel:           cancel,
+        done:             make(chan struct{}),
+        protocolVersion:  cMinProtocolVersion,
+        status: Presence{
+            Status:   ricaEvents.STATUS_ONLINE,
+            LastSeen: time.Now().Unix(),
+        },
+    }
+
+    return ret
+}
+
+// SetOutboundQueue sizes the queue of messages waiting to be written and
+// picks what happens once it is full, call it before Loop
+func (h *ChatHandler) SetOutboundQueue(size int, policy string) {
+    h.outgoingInfo = newUserOutGoingInfo(h.outgoingInfo.ip, size, policy)
+}
+
+func (h *ChatHandler) recoverFromErrors(tag string) {
+    if r := recover(); r != nil {
+        log.Println("!!!PANIC!!!", tag, r)
+    }
+}
+
+// socketReaderLoop hands incoming messages to Loop until the transport fails,
+// it never blocks on Loop once the handler is stopped
+func (h *ChatHandler) socketReaderLoop(socketChannel chan<- interface{}, errorChannel chan<- error) {
+    defer h.workers.Done()
+    defer h.recoverFromErrors("socketReaderLoop")
+
+    for {
+        msg, err := h.transport.ReadMessage()
+
+        // If id blacklisted kill the channel
+        if h.blackList.Contains(h.id) {
+            h.blackList.Add(rasrate.HostOf(h.outgoingInfo.ip))
+            h.blackList.Remove(h.id)
+        }
+
+        // If ip is blacklisted let Loop stop the connection
+        if h.blackList.Contains(rasrate.HostOf(h.outgoingInfo.ip)) {
+            err = errBlackListed
+        } else if err != nil && err.Error() == ricaEvents.ERROR_INVALID_MSGTYPE_ERR {
+            log.Println("Skipping message....")
+            h.onInvalidCommand(err)
+            continue
+        }
+
+        if err != nil {
+            select {
+            case errorChannel <- err:
+            case <-h.ctx.Done():
+            }
+            return
+        }
+
+        select {
+        case socketChannel <- msg:
+        case <-h.ctx.Done():
+            return
+        }
+    }
+}
+
+// socketWriterLoop writes queued messages until the queue is closed and
+// drained or the handler is stopped
+func (h *ChatHandler) socketWriterLoop() {
+    defer h.workers.Done()
+    defer close(h.writerDone)
+    defer h.recoverFromErrors("socketWriterLoop")
+    h.sendWelcome()
+
+    // Pings go out on a fixed schedule, busy connections included
+    pingTicker := time.NewTicker(cPingInterval)
+    defer pingTicker.Stop()
+
+    for {
+        select {
+        case <-h.ctx.Done():
+            return
+        case m, ok := <-h.outgoingInfo.channel:
+            // channel is closed once the handler stops
+            if !ok {
+                return
+            }
+
+            h.handleOutgoingMessage(m)
+        case now := <-pingTicker.C:
+            h.Lock()
+            latency := h.latency
+            h.Unlock()
+
+            h.outgoingInfo.enqueue(&PingMessage{
+                BaseMessage: messageOf(ricaEvents.PING_COMMAND),
+                Type:        now.UnixNano() / int64(time.Millisecond),
+                Latency:     int64(latency / time.Millisecond),
+            })
+        }
+    }
+}
+
+func (h *ChatHandler) sendWelcome() {
+    msg := rasconfig.Current().WelcomeMessage
+    if msg == "" {
+        msg = "# Welcome to server"
+        if f, e := ioutil.ReadFile("/proc/cpuinfo"); e == nil {
+            msg = msg + "\n" + string(f)
+        }
+    }
+    welcomeMsg := &StringMessage{
+        BaseMessage: messageOf(ricaEvents.FROM_SERVER),
+        Message:     msg,
+    }
+    h.transport.WriteMessage(welcomeMsg.Id, welcomeMsg)
+
+    nickMsg := &NickMessage{
+        BaseMessage: messageOf(ricaEvents.SET_NICK_REPLY),
+        OldNick:     h.id,
+        NewNick:     h.id,
+    }
+
+    h.transport.WriteMessage(nickMsg.Id, nickMsg)
+}
+
+func (h *ChatHandler) handleOutgoingMessage(msg interface{}) {
+    baseMsg, ok := msg.(IEventMessage)
+
+    if !ok {
+        panic(fmt.Sprintf("Invalid outgoing message %v", msg))
+    }
+
+    timer := StartStopWatch(pOperationDuration.With("write"))
+    defer timer.ObserveDuration()
+    if !h.wantsEvent(baseMsg.Event()) {
+        return
+    }
+
+    if err := h.transport.WriteMessage(baseMsg.Identity(), baseMsg); err != nil {
+        log.Println("Unable to write socket message", err)
+        h.Stop()
+    }
+}
+
+func (h *ChatHandler) handleSocketMessage(msg interface{}) {
+    // Pongs are sent by clients on their own, anything else is the user
+    if pong, ok := msg.(*PingMessage); ok {
+        h.onPong(pong)
+        return
+    }
+
+    // Commands racing a disconnect the handler decided on are dropped
+    if h.disconnecting {
+        return
+    }
+
+    h.markActive(time.Now())
+
+    if !h.allowSocketMessage(msg) {
+        return
+    }
+
+    switch v := msg.(type) {
+    case *ChatMessage:
+        h.onChatMessage(v)
+    case *StringMessage:
+        h.handleStringMessage(v)
+    case *RecipientContentMessage:
+        h.onRecipientContentMessage(v)
+    case *StatusMessage:
+        h.onSetStatus(v)
+    case *HandshakeMessage:
+        h.onHello(v)
+    }
+}
+
+// allowSocketMessage applies rate limits to messages, joins and nick changes
+// and escalates repeated violations from warning to mute to disconnect
+func (h *ChatHandler) allowSocketMessage(msg interface{}) bool {
+    if h.floodControl == nil {
+        return true
+    }
+
+    action := ""
+    switch v := msg.(type) {
+    case *ChatMessage, *RecipientContentMessage:
+        action = rasconfig.RateLimitMessage
+    case *StringMessage:
+        switch v.EventName {
+        case ricaEvents.JOIN_GROUP_COMMAND:
+            action = rasconfig.RateLimitJoin
+        case ricaEvents.SET_NICK_COMMAND:
+            action = rasconfig.RateLimitNick
+        }
+    case *StatusMessage:
+        // Status changes are announced on every joined group like nick changes
+        action = rasconfig.RateLimitNick
+    }
+
+    if action == "" {
+        return true
+    }
+
+    now := time.Now()
+    if action != rasconfig.RateLimitMessage || !h.flood.muted(now) {
+        if h.floodControl.Allow(action, h.id, h.nick, rasrate.HostOf(h.outgoingInfo.ip)) {
+            return true
+        }
+    }
+
+    h.onFloodViolation(action, h.flood.violate(now))
+    return false
+}
+
+func (h *ChatHandler) onFloodViolation(action string, penalty floodPenalty) {
+    errMsg := &ErrorMessage{
+        BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+        Type:        ricaEvents.ERROR_RATE_LIMITED,
+        Error:       "Slow down, too many " + action + " requests",
+        Body: map[string]interface{}{
+            "action": action,
+        },
+    }
+
+    switch penalty {
+    case floodMute:
+        errMsg.Type = ricaEvents.ERROR_MUTED
+        errMsg.Error = "You have been muted for flooding"
+        errMsg.Body = map[string]interface{}{
+            "action":  action,
+            "seconds": int(h.flood.mutedUntil.Sub(time.Now()).Seconds()),
+        }
+    case floodDisconnect:
+        errMsg.Type = ricaEvents.ERROR_FLOOD_DISCONNECT
+        errMsg.Error = "Disconnected for flooding"
+    }
+
+    h.outgoingInfo.enqueue(errMsg)
+    if penalty == floodDisconnect {
+        log.Println("Disconnecting", h.id, h.outgoingInfo.ip, "for flooding")
+        h.disconnecting = true
+        go h.flushAndStop(cRejectFlushTimeout)
+    }
+}
+
+// onPong records the round trip of the ping pong answers, pongs with an
+// unknown or implausible time are ignored
+func (h *ChatHandler) onPong(pong *PingMessage) {
+    if pong.Type <= 0 {
+        return
+    }
+
+    rtt := time.Since(time.Unix(0, pong.Type*int64(time.Millisecond)))
+    if rtt < 0 || rtt > cPingInterval*4 {
+        return
+    }
+
+    h.Lock()
+    h.latency = rtt
+    h.Unlock()
+    pPingRoundTrip.Observe(rtt.Seconds())
+}
+
+func (h *ChatHandler) handleStringMessage(msg *StringMessage) {
+    switch msg.EventName {
+    case ricaEvents.JOIN_GROUP_COMMAND:
+        h.onJoinGroup(msg)
+    case ricaEvents.LEAVE_GROUP_COMMAND:
+        h.onLeaveGroup(msg)
+    case ricaEvents.SET_NICK_COMMAND:
+        h.onSetNick(msg)
+    case ricaEvents.LIST_MEMBERS_COMMAND:
+        h.onListMembers(msg)
+    case ricaEvents.RESUME_COMMAND:
+        h.onResumeSession(msg)
+    }
+}
+
+func (h *ChatHandler) onRecipientContentMessage(msg *RecipientContentMessage) {
+    switch msg.EventName {
+    case ricaEvents.SEND_RAW_MSG_COMMAND:
+        h.sendTo(ricaEvents.FROM_SERVER, msg.To, msg.Message)
+    }
+}
+
+func (h *ChatHandler) onChatMessage(msg *ChatMessage) {
+    strMsg := strings.TrimSpace(msg.Message)
+    if len(strMsg) <= 0 || len(strMsg) > cMaxMessageLength {
+        return
+    }
+
+    if strings.HasPrefix(msg.To, "@") {
+        h.onDirectMessage(strings.TrimPrefix(msg.To, "@"), msg.Message)
+        return
+    }
+
+    if _, ok := h.groups[msg.To]; ok {
+        nicks, ids := h.resolveMentions(msg.Message)
+        published := &ChatMessage{
+            RecipientMessage: RecipientMessage{
+                BaseMessage: messageOf(ricaEvents.GROUP_MSG_REPLY),
+                To:          msg.To,
+                From:        h.nick,
+            },
+            Message:  msg.Message,
+            Mentions: ids,
+        }
+        h.publish(msg.To, published)
+
+        if len(nicks) > 0 {
+            h.onMentions(msg.To, nicks, ids, published)
+        }
+    }
+}
+
+// resolveMentions returns the mentioned nicks (except sender's own) that
+// belong to someone, up to cMaxMentionsPerMessage, and the ids of those
+// currently online
+func (h *ChatHandler) resolveMentions(message string) ([]string, []string) {
+    nicks := []string{}
+    ids := []string{}
+    for _, nick := range parseMentions(message) {
+        if len(nicks) == cMaxMentionsPerMessage {
+            break
+        }
+
+        if nick == h.nick {
+            continue
+        }
+
+        id, online := h.nickRegistry.IdOf(nick)
+        if !online && !h.knownNick(nick) {
+            continue
+        }
+
+        nicks = append(nicks, nick)
+        if online {
+            ids = append(ids, id)
+        }
+    }
+
+    return nicks, ids
+}
+
+// knownNick tells if an offline nick was seen before or has push devices, so
+// mentions of made up nicks don't end up in the mention index
+func (h *ChatHandler) knownNick(nick string) bool {
+    if h.presenceStore != nil {
+        if _, ok := h.presenceStore.LastSeen(nick); ok {
+            return true
+        }
+    }
+
+    return h.pushNotifier != nil && len(h.pushNotifier.Registrations(nick)) > 0
+}
+
+// onMentions indexes msg for every mentioned nick, sends a mention event to
+// online users (even if they haven't joined group) and pushes to the rest
+func (h *ChatHandler) onMentions(group string, nicks, ids []string, msg *ChatMessage) {
+    if h.mentionStore != nil {
+        for _, nick := range nicks {
+            if err := h.mentionStore.Save(nick, msg.Identity()); err != nil {
+                log.Println("Unable to save mention", nick, err)
+            }
+        }
+    }
+
+    mention := &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: BaseMessage{
+                EventName:    ricaEvents.MENTION_REPLY,
+                Id:           msg.Id,
+                UTCTimestamp: msg.UTCTimestamp,
+            },
+            To:   group,
+            From: msg.From,
+        },
+        Message:  msg.Message,
+        Mentions: msg.Mentions,
+    }
+
+    for _, id := range ids {
+        h.sendToUser(id, mention)
+    }
+
+    if h.pushNotifier != nil {
+        h.pushNotifier.NotifyMentions(group, nicks, msg)
+    }
+}
+
+// onDirectMessage delivers message to nick over its live connection, or as
+// a push notification if nick is offline. The sender gets an echo either way
+func (h *ChatHandler) onDirectMessage(nick, message string) {
+    dm := &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: messageOf(ricaEvents.DIRECT_MSG_REPLY),
+            To:          nick,
+            From:        h.nick,
+        },
+        Message: message,
+    }
+    dm.Stamp()
+    pMessagesPublished.With("direct").Inc()
+
+    if id, online := h.nickRegistry.IdOf(nick); online {
+        h.sendToUser(id, dm)
+    } else if h.pushNotifier == nil || !h.pushNotifier.NotifyDirect(nick, dm) {
+        log.Println("Dropping direct message to offline user", nick)
+    }
+
+    if nick != h.nick {
+        h.outgoingInfo.enqueue(dm)
+    }
+}
+
+func (h *ChatHandler) onListMembers(msg *StringMessage) {
+    groupName := msg.Message
+    if groupName == "" {
+        groupName = ricaEvents.FROM_SERVER
+    }
+
+    membersIds := h.groupInfoManager.GetUsers(groupName)
+    if h.cluster != nil {
+        if ids, err := h.cluster.bus.Members(groupName); err == nil {
+            membersIds = ids
+        } else {
+            log.Println("Unable to list cluster members of", groupName, err)
+        }
+    }
+
+    members := make([]string, len(membersIds))
+    presence := make(map[string]Presence, len(membersIds))
+    i := 0
+    for _, id := range membersIds {
+        var foundNick bool
+        members[i], foundNick = h.nickRegistry.NickOf(id)
+        if !foundNick {
+            members[i] = id
+        }
+
+        if h.presence != nil {
+            presence[members[i]] = h.presence.Get(id)
+        }
+        i++
+    }
+
+    h.outgoingInfo.enqueue(&GroupListMessage{
+        RecipientContentMessage: RecipientContentMessage{
+            RecipientMessage: RecipientMessage{
+                BaseMessage: messageOf(ricaEvents.LIST_MEMBERS_REPLY),
+                To:          groupName,
+                From:        ricaEvents.FROM_SERVER,
+            },
+            Message: members,
+        },
+        Presence: presence,
+    })
+}
+
+// validGroupName refuses names other transports can't carry, IRC splits
+// commands on spaces, commas and line breaks
+func validGroupName(name string) bool {
+    if name == "" || name == ricaEvents.FROM_SERVER {
+        return false
+    }
+
+    for _, r := range name {
+        if r == ' ' || r == ',' || r < 0x20 || r == 0x7f {
+            return false
+        }
+    }
+
+    return true
+}
+
+func (h *ChatHandler) onJoinGroup(msg *StringMessage) {
+    timer := StartStopWatch(pOperationDuration.With("join"))
+    defer timer.ObserveDuration()
+
+    if !validGroupName(msg.Message) {
+        h.outgoingInfo.enqueue(&ErrorMessage{
+            BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+            Type:        ricaEvents.ERROR_INVALID_GROUP,
+            Error:       "Group names can't be empty or contain spaces, commas or control characters",
+            Body:        msg.Message,
+        })
+        return
+    }
+
+    h.Lock()
+    h.groups[msg.Message] = struct{}{}
+    h.Unlock()
+    h.groupInfoManager.AddUser(msg.Message, h.id, h.outgoingInfo)
+    h.cluster.join(msg.Message, h.id)
+
+    h.publish(msg.Message, &RecipientMessage{
+        BaseMessage: messageOf(ricaEvents.JOIN_GROUP_REPLY),
+        To:          msg.Message,
+        From:        h.nick,
+    })
+}
+
+func (h *ChatHandler) onLeaveGroup(msg *StringMessage) {
+    timer := StartStopWatch(pOperationDuration.With("leave"))
+    defer timer.ObserveDuration()
+
+    h.publish(msg.Message, &RecipientMessage{
+        BaseMessage: messageOf(ricaEvents.LEAVE_GROUP_REPLY),
+        To:          msg.Message,
+        From:        h.nick,
+    })
+
+    h.groupInfoManager.RemoveUser(msg.Message, h.id)
+    h.cluster.leave(msg.Message, h.id)
+    h.Lock()
+    delete(h.groups, msg.Message)
+    h.Unlock()
+}
+
+func (h *ChatHandler) onSetNick(msg *StringMessage) {
+    timer := StartStopWatch(pOperationDuration.With("set_nick"))
+    defer timer.ObserveDuration()
+
+    oldNick := h.nick
+    newNick, err := h.nickRegistry.SetBestPossibleNick(h.id, msg.Message)
+
+    if err == nil {
+        if newNick != oldNick {
+            h.saveLastSeen()
+            if h.floodControl != nil {
+                h.floodControl.ChangeUser(oldNick, newNick)
+            }
+        }
+
+        h.nick = newNick
+        nickMsg := &NickMessage{
+            BaseMessage: messageOf(ricaEvents.SET_NICK_REPLY),
+            OldNick:     oldNick,
+            NewNick:     newNick,
+        }
+
+        // Queued so it follows a server-hello the same command produced
+        h.outgoingInfo.enqueue(nickMsg)
+        h.publishOnJoinedChannels(nickMsg.EventName, nickMsg)
+        return
+    }
+
+    log.Println("Unable to change nick", err)
+}
+
+// NickLost tells the handler another node took nick over after this node's
+// cluster claim on it expired, the handler falls back to its id as nick
+func (h *ChatHandler) NickLost(nick string) {
+    select {
+    case h.nickLost <- nick:
+    default:
+    }
+}
+
+func (h *ChatHandler) onNickLost(nick string) {
+    if h.nick != nick {
+        return
+    }
+
+    log.Println("Nick", nick, "was taken over on another node, renaming", h.id)
+    h.onSetNick(&StringMessage{
+        BaseMessage: messageOf(ricaEvents.SET_NICK_COMMAND),
+        Message:     h.id,
+    })
+}
+
+// joinedGroups returns a snapshot of the groups the handler is in
+func (h *ChatHandler) joinedGroups() []string {
+    h.Lock()
+    defer h.Unlock()
+
+    joinedGroups := make([]string, 0, len(h.groups))
+    for g := range h.groups {
+        joinedGroups = append(joinedGroups, g)
+    }
+
+    return joinedGroups
+}
+
+func (h *ChatHandler) publishOnJoinedChannels(eventName string, msg interface{}) {
+    timer := StartStopWatch(pOperationDuration.With("publish_nick"))
+    defer timer.ObserveDuration()
+
+    for _, g := range h.joinedGroups() {
+        h.publish(g, &RecipientContentMessage{
+            RecipientMessage: RecipientMessage{
+                BaseMessage: messageOf(ricaEvents.MEMBER_NICK_SET_REPLY),
+                To:          g,
+                From:        h.id,
+            },
+            Message: msg,
+        })
+    }
+}
+
+func (h *ChatHandler) publish(groupName string, msg IEventMessage) {
+    timer := StartStopWatch(pPublishDuration)
+    defer timer.ObserveDuration()
+
+    msg.Stamp()
+    h.transport.BeginBatch(msg.Identity(), msg)
+    h.chatStore.Save(groupName, msg.Identity(), msg)
+
+    pMessagesPublished.With("group").Inc()
+    groupMembers := h.groupInfoManager.GetUsers(groupName)
+    for _, id := range groupMembers {
+        h.sendTo(groupName, id, msg)
+    }
+
+    h.transport.FlushBatch(msg.Identity())
+    h.cluster.publish(groupName, "", msg)
+}
+
+func (h *ChatHandler) sendTo(groupName, name string, msg interface{}) {
+    defer h.recoverFromErrors("sendTo")
+    deliverTo(h.groupInfoManager, groupName, name, msg)
+}
+
+// sendToUser delivers msg to user id whether it is connected here or to
+// another node
+func (h *ChatHandler) sendToUser(id string, msg IEventMessage) {
+    defer h.recoverFromErrors("sendToUser")
+    if !deliverTo(h.groupInfoManager, ricaEvents.FROM_SERVER, id, msg) {
+        h.cluster.publish(ricaEvents.FROM_SERVER, id, msg)
+    }
+}
+
+// deliverTo enqueues msg for user name of group, false if name isn't connected here
+func deliverTo(groupInfo GroupInfoManager, groupName, name string, msg interface{}) bool {
+    tmp := groupInfo.GetUserInfoObject(groupName, name)
+    if tmp == nil {
+        return false
+    }
+
+    if inf, ok := tmp.(*userOutGoingInfo); ok {
+        inf.enqueue(msg)
+    } else {
+        log.Println("Invalid channel type skipping publish to", name)
+    }
+
+    return true
+}
+
+// Loop over incoming and out going socket channels until the connection
+// fails or Stop is called, then clean up. Loop returns once the reader and
+// writer goroutines have exited
+func (h *ChatHandler) Loop() {
+    defer close(h.done)
+    defer h.workers.Wait()
+    defer h.Stop()
+    defer h.recoverFromErrors("Loop")
+
+    // Stopped before it started, nothing to clean up
+    if h.ctx.Err() != nil {
+        return
+    }
+
+    h.outgoingInfo.onOverflow = h.Stop
+    h.nickRegistry.Register(h.id, h.nick)
+    h.Lock()
+    h.groups[ricaEvents.FROM_SERVER] = struct{}{}
+    h.Unlock()
+    h.groupInfoManager.AddUser(ricaEvents.FROM_SERVER, h.id, h.outgoingInfo)
+    h.cluster.join(ricaEvents.FROM_SERVER, h.id)
+    h.updatePresence()
+
+    // Neither channel is ever closed, senders give up once ctx is done
+    readErrorChannel := make(chan error)
+    sockChannel := make(chan interface{}, 32)
+
+    h.workers.Add(2)
+    go h.socketReaderLoop(sockChannel, readErrorChannel)
+    go h.socketWriterLoop()
+
+    presenceTicker := time.NewTicker(cPresenceCheckInterval)
+    defer presenceTicker.Stop()
+
+selectLoop:
+    for {
+        select {
+        case m := <-sockChannel:
+            h.handleSocketMessage(m)
+        case now := <-presenceTicker.C:
+            h.checkIdle(now)
+        case nick := <-h.nickLost:
+            h.onNickLost(nick)
+        case e := <-readErrorChannel:
+            if ne, ok := e.(net.Error); ok && ne.Timeout() {
+                log.Println("Closing unresponsive connection", h.id, h.outgoingInfo.ip)
+                pUnresponsiveDisconnects.With(transportName(h.transport)).Inc()
+            } else {
+                log.Println("Error received", e)
+            }
+            break selectLoop
+        case <-h.ctx.Done():
+            break selectLoop
+        }
+    }
+
+    h.Stop()
+    h.cleanup()
+}
+
+// Stop ends the connection: it cancels the handler's context, closes the
+// outgoing queue and the transport. It can be called any number of times
+// from any goroutine, Loop notices and cleans up
+func (h *ChatHandler) Stop() {
+    h.stopOnce.Do(func() {
+        h.cancel()
+        h.outgoingInfo.close()
+        h.transport.Close()
+    })
+}
+
+// Done is closed once Loop has cleaned up and returned
+func (h *ChatHandler) Done() <-chan struct{} {
+    return h.done
+}
+
+// cleanup leaves every group and releases the nick, called once by Loop
+func (h *ChatHandler) cleanup() {
+    h.Lock()
+    currentGroupsMap := h.groups
+    h.groups = make(map[string]interface{})
+    h.Unlock()
+    joinedGroups := make([]string, 0, len(currentGroupsMap))
+
+    for g := range currentGroupsMap {
+        joinedGroups = append(joinedGroups, g)
+        h.groupInfoManager.RemoveUser(g, h.id)
+        h.cluster.leave(g, h.id)
+    }
+
+    h.nickRegistry.Unregister(h.id)
+    h.goOffline(joinedGroups)
+    for _, groupName := range joinedGroups {
+        h.publish(groupName, &RecipientMessage{
+            BaseMessage: messageOf(ricaEvents.LEAVE_GROUP_REPLY),
+            To:          groupName,
+            From:        h.nick,
+        })
+    }
+}
+
+// GoAway sends notice to the client, waits up to timeout for the writer to
+// flush everything queued and stops the handler
+func (h *ChatHandler) GoAway(notice *GoingAwayMessage, timeout time.Duration) {
+    h.outgoingInfo.enqueue(notice)
+    h.flushAndStop(timeout)
+}
+
+// flushAndStop closes the outgoing queue, waits up to timeout for the writer
+// to write what is left in it and stops the handler
+func (h *ChatHandler) flushAndStop(timeout time.Duration) {
+    h.outgoingInfo.close()
+
+    select {
+    case <-h.writerDone:
+    case <-h.done:
+    case <-time.After(timeout):
+        log.Println("Timed out draining outgoing messages for", h.id)
+    }
+
+    h.Stop()
+}
+
+// resumableSession captures nick and joined groups for a resume token
+func (h *ChatHandler) resumableSession() *ResumableSession {
+    h.Lock()
+    defer h.Unlock()
+
+    groups := make([]string, 0, len(h.groups))
+    for g := range h.groups {
+        if g != ricaEvents.FROM_SERVER {
+            groups = append(groups, g)
+        }
+    }
+
+    return &ResumableSession{
+        Nick:    h.nick,
+        Groups:  groups,
+        Expires: time.Now().Add(cResumableSessionTTL),
+    }
+}
+
+func (h *ChatHandler) onResumeSession(msg *StringMessage) {
+    var session *ResumableSession
+    ok := false
+    if h.sessionStore != nil {
+        session, ok = h.sessionStore.Take(msg.Message)
+    }
+
+    if !ok {
+        errMsg := &ErrorMessage{
+            BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+            Type:        ricaEvents.ERROR_RESUME_FAILED,
+            Error:       "Unknown or expired resume token",
+        }
+        h.outgoingInfo.enqueue(errMsg)
+        return
+    }
+
+    h.onSetNick(&StringMessage{
+        BaseMessage: messageOf(ricaEvents.SET_NICK_COMMAND),
+        Message:     session.Nick,
+    })
+
+    for _, g := range session.Groups {
+        h.onJoinGroup(&StringMessage{
+            BaseMessage: messageOf(ricaEvents.JOIN_GROUP_COMMAND),
+            Message:     g,
+        })
+    }
+
+    resumed := &StringMessage{
+        BaseMessage: messageOf(ricaEvents.SESSION_RESUMED_REPLY),
+        Message:     h.nick,
+    }
+    h.outgoingInfo.enqueue(resumed)
+}
@@ -0,0 +1,203 @@
+package rica_test
+
+import (
+    "errors"
+    "fmt"
+    "runtime"
+    "sync"
+    "testing"
+    "time"
+
+    "sibte.so/rica"
+    "sibte.so/rica/consts"
+    "sibte.so/rica/ricatest"
+)
+
+var listCommand = fmt.Sprintf(`{"@": %q}`, ricaEvents.LIST_MEMBERS_COMMAND)
+
+// newLifecycleScenario records the goroutine count every handler of the
+// scenario has to return to
+func newLifecycleScenario(t *testing.T) (*ricatest.Scenario, int) {
+    s := ricatest.NewScenario(t)
+    return s, runtime.NumGoroutine()
+}
+
+// newHandler creates a handler of s that isn't running yet
+func newHandler(s *ricatest.Scenario) (*rica.ChatHandler, *ricatest.Transport) {
+    trans := ricatest.NewTransport()
+    return rica.NewChatHandler(s.Nicks, s.Groups, trans, s.Store, "127.0.0.1:1234", s.BlackList), trans
+}
+
+// start runs Loop and returns a channel closed once it returns
+func start(h *rica.ChatHandler) chan struct{} {
+    returned := make(chan struct{})
+    go func() {
+        h.Loop()
+        close(returned)
+    }()
+
+    return returned
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+    t.Helper()
+
+    select {
+    case <-ch:
+    case <-time.After(2 * time.Second):
+        t.Fatal("Timed out waiting for", what)
+    }
+}
+
+// expectNoLeaks waits for the goroutine count to fall back to baseline
+func expectNoLeaks(t *testing.T, baseline int) {
+    t.Helper()
+
+    deadline := time.Now().Add(2 * time.Second)
+    for runtime.NumGoroutine() > baseline {
+        if time.Now().After(deadline) {
+            buf := make([]byte, 1<<16)
+            t.Fatalf("%d goroutines leaked\n%s", runtime.NumGoroutine()-baseline, buf[:runtime.Stack(buf, true)])
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestPeerHangupEndsLoop(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+    id, ok := s.Nicks.IdOf("alice")
+    if !ok {
+        t.Fatal("alice not registered")
+    }
+
+    c.Hangup()
+    if c.Transport().CloseCount() != 1 {
+        t.Error("Transport closed", c.Transport().CloseCount(), "times, expected once")
+    }
+
+    if _, ok := s.Nicks.NickOf(id); ok {
+        t.Error("Nick still registered after the connection ended")
+    }
+
+    if s.Groups.GetUserInfoObject(ricaEvents.FROM_SERVER, id) != nil {
+        t.Error("Handler still member of", ricaEvents.FROM_SERVER)
+    }
+
+    expectNoLeaks(t, baseline)
+}
+
+func TestConcurrentStopIsIdempotent(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+    h := c.Handler()
+
+    var wg sync.WaitGroup
+    for i := 0; i < 16; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            h.Stop()
+        }()
+    }
+    wg.Wait()
+
+    c.WaitClosed()
+    h.Stop()
+
+    if c.Transport().CloseCount() != 1 {
+        t.Error("Transport closed", c.Transport().CloseCount(), "times, expected once")
+    }
+
+    expectNoLeaks(t, baseline)
+}
+
+func TestStopBeforeLoop(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    h, _ := newHandler(s)
+    h.Stop()
+
+    waitClosed(t, start(h), "Loop to return")
+    if nicks := s.Nicks.GetMappingSnapshot(); len(nicks) != 0 {
+        t.Error("Stopped handler registered its nick", nicks)
+    }
+
+    expectNoLeaks(t, baseline)
+}
+
+func TestStopWhileMessagesArrive(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+
+    // The peer keeps sending while the handler is stopped underneath it
+    senderDone := make(chan struct{})
+    go func() {
+        defer close(senderDone)
+        for c.Send(listCommand) {
+        }
+    }()
+
+    time.Sleep(20 * time.Millisecond)
+    c.Handler().Stop()
+
+    c.WaitClosed()
+    waitClosed(t, senderDone, "sender to give up")
+    expectNoLeaks(t, baseline)
+}
+
+func TestWriteFailureStopsHandler(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+    c.Transport().FailWrites(errors.New("broken pipe"))
+
+    c.Send(listCommand)
+    c.WaitClosed()
+    expectNoLeaks(t, baseline)
+}
+
+func TestOverflowDisconnectStopsHandler(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    h, trans := newHandler(s)
+    h.SetOutboundQueue(1, rica.SlowClientDisconnect)
+
+    // Hold the writer on the welcome message so the queue fills up
+    release := trans.StallWrites()
+    defer release()
+
+    returned := start(h)
+    for i := 0; i < 4 && trans.Send([]byte(listCommand)); i++ {
+    }
+
+    waitClosed(t, returned, "Loop to return")
+    expectNoLeaks(t, baseline)
+}
+
+func TestGoAwayFlushesNoticeAndStops(t *testing.T) {
+    s, baseline := newLifecycleScenario(t)
+    defer s.Close()
+
+    c := s.Connect("alice")
+    c.Handler().GoAway(&rica.GoingAwayMessage{
+        BaseMessage: rica.BaseMessage{EventName: ricaEvents.SERVER_GOING_AWAY},
+    }, time.Second)
+    c.WaitClosed()
+
+    events, _ := c.Transport().Events()
+    if len(events) == 0 || events[len(events)-1].Name != ricaEvents.SERVER_GOING_AWAY {
+        t.Error("Expected the going away notice to be written last, got", events)
+    }
+
+    expectNoLeaks(t, baseline)
+}
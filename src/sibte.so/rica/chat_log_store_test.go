@@ -0,0 +1,42 @@
+package rica
+
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+
+    "sibte.so/rica/consts"
+)
+
+func BenchmarkChatLogStoreSave(b *testing.B) {
+    dir, err := ioutil.TempDir("", "rica-bench")
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    store, err := NewChatLogStore(dir + "/chats.leveldb")
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer store.Close()
+
+    msg := &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: messageOf(ricaEvents.GROUP_MSG_REPLY),
+            To:          "lounge",
+            From:        "alice",
+        },
+        Message: strings.Repeat("x", 64),
+    }
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        msg.Id = pSnowFlake.Next()
+        if err := store.Save("lounge", msg.Id, msg); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
@@ -0,0 +1,999 @@
+package rica
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "github.com/julienschmidt/httprouter"
+
+    "sibte.so/rasconfig"
+    "sibte.so/raspush"
+    "sibte.so/rasrate"
+    "sibte.so/rica/consts"
+)
+
+// Maximum body size accepted for a single command posted over HTTP
+const cMaxPostedCommandSize = 64 << 10
+
+type ChatService struct {
+    sync.Mutex
+    groupInfo     GroupInfoManager
+    chatStore     *ChatLogStore
+    mentionStore  *MentionStore
+    sessionStore  *SessionStore
+    presence      *PresenceRegistry
+    presenceStore *PresenceStore
+    cluster       *clusterNode
+    floodControl  *FloodControl
+    connections   *ConnectionLimiter
+    nickRegistry  *NickRegistry
+    upgrader      *websocket.Upgrader
+    pushNotifier  *PushNotifier
+    prefsStore    *NotificationPrefsStore
+    httpMux       *http.ServeMux
+    blackList     *BlackList
+    checkOrigin   func(r *http.Request) bool
+    queueSize     int
+    queuePolicy   string
+    draining      bool
+    handlers      map[*ChatHandler]struct{}
+    apiTokens     map[string]*ChatHandler
+    handlersDone  sync.WaitGroup
+    listeners     []net.Listener
+    features      []string
+}
+
+func NewChatService(appConfig rasconfig.ApplicationConfig) *ChatService {
+    var bus ClusterBus
+    if appConfig.ClusterRedisURL != "" {
+        var err error
+        if bus, err = NewRedisClusterBus(appConfig.ClusterRedisURL); err != nil {
+            log.Panic(err)
+        }
+    }
+
+    return NewClusteredChatService(appConfig, bus)
+}
+
+// claimWorkerId refuses to start a node whose worker id is already in use,
+// a node is identified by its host and database so it can reclaim its own
+// id after a crash
+func claimWorkerId(bus ClusterBus, dbPath string) {
+    hostname, _ := os.Hostname()
+    workerId := pSnowFlake.WorkerId()
+
+    claimed, err := bus.ClaimWorkerId(workerId, hostname+":"+dbPath)
+    if err != nil {
+        log.Panic(err)
+    }
+
+    if !claimed {
+        pWorkerIdCollisions.Inc()
+        log.Panicf("Worker id %v is used by another node, set a unique worker_id", workerId)
+    }
+}
+
+// NewClusteredChatService creates a service sharing messages, nicks and group
+// membership with other nodes over bus, a nil bus runs a single node
+func NewClusteredChatService(appConfig rasconfig.ApplicationConfig, bus ClusterBus) *ChatService {
+    initChatHandlerTypes()
+    if appConfig.WorkerId != nil {
+        if err := pSnowFlake.SetWorkerId(uint32(*appConfig.WorkerId)); err != nil {
+            log.Panic(err)
+        }
+    }
+
+    if bus != nil {
+        claimWorkerId(bus, appConfig.DBPath)
+    }
+    log.Println("Generating message ids as worker", pSnowFlake.WorkerId())
+
+    store, e := NewChatLogStore(rasconfig.Current().DBPath+"/chats.leveldb")
+
+    if e != nil {
+        log.Panic(e)
+    }
+
+    mentionStore, e := NewMentionStore(rasconfig.Current().DBPath + "/mentions.leveldb")
+    if e != nil {
+        log.Panic(e)
+    }
+
+    sessionStore, e := NewSessionStore(rasconfig.Current().DBPath + "/sessions.leveldb")
+    if e != nil {
+        log.Panic(e)
+    }
+
+    presenceStore, e := NewPresenceStore(rasconfig.Current().DBPath + "/presence.leveldb")
+    if e != nil {
+        log.Panic(e)
+    }
+
+    wsUpgrader := &websocket.Upgrader{
+        ReadBufferSize:  1024,
+        WriteBufferSize: 1024,
+    }
+
+    // Allowed origins are read on every request so config reloads apply
+    checkOrigin := func(r *http.Request) bool {
+        origin := r.Header.Get("Origin")
+        allowedOrigins := rasconfig.Current().AllowedOrigins
+        if origin == "" || len(allowedOrigins) == 0 {
+            return true
+        }
+
+        for _, item := range allowedOrigins {
+            if strings.Compare(item, origin) == 0 {
+                return true
+            }
+        }
+
+        log.Println("Denying connection due to missing origin " + origin)
+        return false
+    }
+
+    // Without allowed origins web sockets keep the same origin check
+    wsUpgrader.CheckOrigin = func(r *http.Request) bool {
+        if len(rasconfig.Current().AllowedOrigins) > 0 {
+            return checkOrigin(r)
+        }
+
+        return isSameOrigin(r)
+    }
+
+    ret := &ChatService{
+        groupInfo:     NewInMemoryGroupInfo(),
+        nickRegistry:  NewNickRegistry(),
+        chatStore:     store,
+        mentionStore:  mentionStore,
+        sessionStore:  sessionStore,
+        presence:      NewPresenceRegistry(),
+        presenceStore: presenceStore,
+        floodControl:  NewFloodControl(appConfig.RateLimits),
+        connections:   NewConnectionLimiter(appConfig.MaxConnectionsPerIP),
+        upgrader:      wsUpgrader,
+        blackList:     NewBlackList(),
+        checkOrigin:   checkOrigin,
+        queueSize:     appConfig.OutboundQueueSize,
+        queuePolicy:   appConfig.SlowClientPolicy,
+        handlers:      make(map[*ChatHandler]struct{}),
+        apiTokens:     make(map[string]*ChatHandler),
+    }
+
+    if len(rasconfig.Current().PushConfig) > 0 {
+        sender, err := raspush.NewSender(rasconfig.Current().PushConfig)
+        if err != nil {
+            log.Panic(err)
+        }
+
+        prefsStore, err := NewNotificationPrefsStore(rasconfig.Current().DBPath + "/notifications.leveldb")
+        if err != nil {
+            log.Panic(err)
+        }
+
+        regStore, err := NewPushRegistrationStore(rasconfig.Current().DBPath + "/push_registrations.leveldb")
+        if err != nil {
+            log.Panic(err)
+        }
+
+        notifier, err := NewPushNotifier(NewPushWorker(sender, prefsStore), ret.nickRegistry, regStore)
+        if err != nil {
+            log.Panic(err)
+        }
+
+        sender.OnInvalidToken = func(sub *raspush.Subscription) {
+            log.Println("Unregistering invalid push token", sub.Platform, sub.Token)
+            notifier.Unregister(sub.Token)
+        }
+
+        ret.pushNotifier = notifier
+        ret.prefsStore = prefsStore
+    }
+
+    if bus != nil {
+        nodeId, err := newTransportSessionId()
+        if err != nil {
+            log.Panic(err)
+        }
+
+        ret.cluster = &clusterNode{id: nodeId, bus: bus}
+        ret.nickRegistry.cluster = bus
+        bus.OnNickLost(ret.onNickLost)
+        if err = bus.Subscribe(ret.onClusterMessage); err != nil {
+            log.Panic(err)
+        }
+    }
+
+    ret.blackList.SetConfigured(appConfig.BlackList)
+    rasconfig.OnReload(ret.applyConfig)
+    return ret
+}
+
+// onNickLost renames the local user whose expired claim on nick went to a
+// user on another node
+func (c *ChatService) onNickLost(nick, id string) {
+    c.Lock()
+    defer c.Unlock()
+
+    for h := range c.handlers {
+        if h.id == id {
+            h.NickLost(nick)
+            return
+        }
+    }
+}
+
+// onClusterMessage delivers a message published on another node to the local
+// members of its group, or only to its addressee. Group messages are saved so
+// every node has the full history
+func (c *ChatService) onClusterMessage(env *ClusterEnvelope) {
+    if env.Node == c.cluster.id {
+        return
+    }
+
+    msg, err := decodeClusterMessage(env)
+    if err != nil {
+        log.Println("Dropping cluster message", err)
+        return
+    }
+
+    if env.To != "" {
+        deliverTo(c.groupInfo, ricaEvents.FROM_SERVER, env.To, msg)
+        return
+    }
+
+    if presence, ok := msg.(*PresenceMessage); ok {
+        c.presence.onPresenceChange(presence)
+    } else {
+        c.chatStore.Save(env.Group, msg.Identity(), msg)
+    }
+    for _, id := range c.groupInfo.GetUsers(env.Group) {
+        deliverTo(c.groupInfo, env.Group, id, msg)
+    }
+}
+
+// applyConfig picks up the settings a config reload can change
+func (c *ChatService) applyConfig(conf *rasconfig.ApplicationConfig) {
+    c.floodControl.Update(conf.RateLimits)
+    c.blackList.SetConfigured(conf.BlackList)
+}
+
+func isSameOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+
+    u, err := url.Parse(origin)
+    if err != nil {
+        return false
+    }
+
+    return strings.EqualFold(u.Host, r.Host)
+}
+
+func (c *ChatService) WithRESTRoutes(prefix string) http.Handler {
+    mux := http.NewServeMux()
+    mux.Handle(prefix+"/api/", c.httpRoutes(prefix+"/api", httprouter.New()))
+    c.httpMux = mux
+    return c
+}
+
+func (c *ChatService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    if strings.HasPrefix(req.URL.Path, "/chat/api") {
+        c.httpMux.ServeHTTP(w, req)
+        return
+    }
+
+    if strings.HasPrefix(req.URL.Path, "/chat/sse") {
+        c.serveSSE(w, req)
+        return
+    }
+
+    if strings.HasPrefix(req.URL.Path, "/chat/poll") {
+        c.serveLongPoll(w, req)
+        return
+    }
+
+    c.upgradeConnectionToWebSocket(w, req)
+}
+
+func (c *ChatService) httpRoutes(prefix string, router *httprouter.Router) http.Handler {
+    if c.pushNotifier != nil {
+        router.POST(prefix+"/register", c.onPushSubscribe)
+        router.POST(prefix+"/unregister", c.onPushUnsubscribe)
+        router.GET(prefix+"/devices/:user", c.onGetPushDevices)
+        router.GET(prefix+"/vapid_key", c.onGetVAPIDKey)
+        router.GET(prefix+"/notifications/:user", c.onGetNotificationPrefs)
+        router.POST(prefix+"/notifications/:user", c.onSetNotificationPrefs)
+    }
+
+    router.GET(prefix+"/channel/:id/message", c.onGetChatHistory)
+    router.GET(prefix+"/channel/:id/message/:msg_id", c.onGetChatMessage)
+    router.GET(prefix+"/channel", c.onGetChannels)
+    router.GET(prefix+"/channel/:id/info", c.onGetChannelInfo)
+    router.GET(prefix+"/blacklist/:uid/:action", c.onBlackListUser)
+    router.GET(prefix+"/mentions", c.onGetMentions)
+    router.GET(prefix+"/stats", c.onGetStats)
+    router.GET(prefix+"/presence/:nick", c.onGetPresence)
+
+    return router
+}
+
+// newChatHandler creates a handler wired to the service's shared state
+func (c *ChatService) newChatHandler(transporter IMessageTransport, ip string) *ChatHandler {
+    handler := NewChatHandler(c.nickRegistry, c.groupInfo, transporter, c.chatStore, ip, c.blackList)
+    handler.pushNotifier = c.pushNotifier
+    handler.mentionStore = c.mentionStore
+    handler.sessionStore = c.sessionStore
+    handler.cluster = c.cluster
+    handler.presence = c.presence
+    handler.presenceStore = c.presenceStore
+    handler.floodControl = c.floodControl
+    handler.SetOutboundQueue(c.queueSize, c.queuePolicy)
+    handler.extraFeatures = c.features
+    return handler
+}
+
+// AddFeatures announces features served outside the chat service (like gif
+// search) in hello replies, call it before accepting connections
+func (c *ChatService) AddFeatures(features ...string) {
+    c.features = append(c.features, features...)
+}
+
+// acquireConnection reserves a slot for the client, replying with 429 when
+// its IP already holds max_connections_per_ip connections
+func (c *ChatService) acquireConnection(w http.ResponseWriter, req *http.Request) bool {
+    if !c.Ready() {
+        http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+        return false
+    }
+
+    if c.blackList.Contains(rasrate.HostOf(req.RemoteAddr)) {
+        http.Error(w, "Forbidden", http.StatusForbidden)
+        return false
+    }
+
+    if c.connections.TryAcquire(rasrate.HostOf(req.RemoteAddr)) {
+        return true
+    }
+
+    http.Error(w, "Too many connections", http.StatusTooManyRequests)
+    return false
+}
+
+// runChatHandler loops handler and frees its connection slot once it stops
+func (c *ChatService) runChatHandler(handler *ChatHandler, ip string) {
+    defer c.connections.Release(rasrate.HostOf(ip))
+
+    c.Lock()
+    if c.draining {
+        c.Unlock()
+        handler.Stop()
+        return
+    }
+    c.handlers[handler] = struct{}{}
+    c.apiTokens[handler.apiToken] = handler
+    c.handlersDone.Add(1)
+    c.Unlock()
+    defer func() {
+        c.Lock()
+        delete(c.handlers, handler)
+        delete(c.apiTokens, handler.apiToken)
+        c.Unlock()
+        c.handlersDone.Done()
+    }()
+
+    gauge := pConnectionsGauge.With(transportName(handler.transport))
+    gauge.Inc()
+    defer gauge.Dec()
+
+    handler.Loop()
+}
+
+func (c *ChatService) upgradeConnectionToWebSocket(w http.ResponseWriter, req *http.Request) bool {
+    if !c.acquireConnection(w, req) {
+        return false
+    }
+
+    conn, err := c.upgrader.Upgrade(w, req, nil)
+    if err == nil {
+        transporter := NewWebsocketMessageTransport(conn)
+        handler := c.newChatHandler(transporter, req.RemoteAddr)
+        go c.runChatHandler(handler, req.RemoteAddr)
+        return true
+    }
+
+    c.connections.Release(rasrate.HostOf(req.RemoteAddr))
+    log.Println("Error upgrading connection...", err)
+    return false
+}
+
+func (c *ChatService) serveSSE(w http.ResponseWriter, req *http.Request) {
+    if !c.checkOrigin(req) {
+        http.Error(w, "Origin not allowed", http.StatusForbidden)
+        return
+    }
+
+    switch req.Method {
+    case "GET":
+        c.openSSEStream(w, req)
+    case "POST":
+        c.postTransportCommand(w, req)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func (c *ChatService) openSSEStream(w http.ResponseWriter, req *http.Request) {
+    sessionId, err := newTransportSessionId()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    if !c.acquireConnection(w, req) {
+        return
+    }
+
+    transporter := NewSSEMessageTransport(sessionId)
+    pTransportSessions.save(sessionId, transporter)
+    defer pTransportSessions.delete(sessionId)
+
+    handler := c.newChatHandler(transporter, req.RemoteAddr)
+    go c.runChatHandler(handler, req.RemoteAddr)
+
+    if err := transporter.Serve(w, req.Context().Done()); err != nil {
+        log.Println("SSE stream closed with error", sessionId, err)
+    }
+}
+
+func (c *ChatService) serveLongPoll(w http.ResponseWriter, req *http.Request) {
+    if !c.checkOrigin(req) {
+        http.Error(w, "Origin not allowed", http.StatusForbidden)
+        return
+    }
+
+    switch req.Method {
+    case "GET":
+        if req.URL.Query().Get("session") == "" {
+            c.openLongPollSession(w, req)
+        } else {
+            c.pollLongPollSession(w, req)
+        }
+    case "POST":
+        c.postTransportCommand(w, req)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func (c *ChatService) openLongPollSession(w http.ResponseWriter, req *http.Request) {
+    sessionId, err := newTransportSessionId()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    if !c.acquireConnection(w, req) {
+        return
+    }
+
+    transporter := NewLongPollMessageTransport(sessionId)
+    pTransportSessions.save(sessionId, transporter)
+    go func() {
+        transporter.ExpireWhenIdle(cLongPollIdleTimeout)
+        pTransportSessions.delete(sessionId)
+    }()
+
+    handler := c.newChatHandler(transporter, req.RemoteAddr)
+    go c.runChatHandler(handler, req.RemoteAddr)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "session":  sessionId,
+        "messages": []IEventMessage{},
+    })
+}
+
+func (c *ChatService) pollLongPollSession(w http.ResponseWriter, req *http.Request) {
+    sessionId := req.URL.Query().Get("session")
+    t, ok := pTransportSessions.get(sessionId)
+    transporter, isLongPoll := t.(*LongPollMessageTransport)
+    if !ok || !isLongPoll {
+        http.Error(w, "Unknown session", http.StatusNotFound)
+        return
+    }
+
+    msgs, err := transporter.Poll(cLongPollTimeout, req.Context().Done())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusGone)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-cache")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "session":  sessionId,
+        "messages": msgs,
+    })
+}
+
+// postTransportCommand delivers a command body to the transport owning ?session=<id>
+func (c *ChatService) postTransportCommand(w http.ResponseWriter, req *http.Request) {
+    transporter, ok := pTransportSessions.get(req.URL.Query().Get("session"))
+    if !ok {
+        http.Error(w, "Unknown session", http.StatusNotFound)
+        return
+    }
+
+    msg, err := ioutil.ReadAll(io.LimitReader(req.Body, cMaxPostedCommandSize))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if err := transporter.PostMessage(msg); err != nil {
+        http.Error(w, err.Error(), http.StatusGone)
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// onPushSubscribe registers a device for the user the caller is authorized as
+func (c *ChatService) onPushSubscribe(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+    reg, err := parsePushRegistration(req)
+    if err != nil {
+        log.Println("Invalid push subscription", err)
+        fmt.Fprintf(w, "false")
+        return
+    }
+
+    if !c.authorizeUser(w, req, reg.User) {
+        return
+    }
+
+    if _, ok := c.pushNotifier.worker.sender.Provider(reg.Platform); !ok {
+        fmt.Fprintf(w, "false")
+        return
+    }
+
+    if err := c.pushNotifier.Register(reg); err != nil {
+        log.Println("Unable to save push registration", err)
+        fmt.Fprintf(w, "false")
+        return
+    }
+
+    fmt.Fprintf(w, "true")
+}
+
+// onPushUnsubscribe removes a device by its token (or web push endpoint),
+// which only the device knows, or by the id listed in devices for a user the
+// caller is authorized as
+func (c *ChatService) onPushUnsubscribe(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+    token := req.FormValue("token")
+    user, id := req.FormValue("user"), req.FormValue("id")
+    if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+        body := &struct {
+            Token    string `json:"token"`
+            Endpoint string `json:"endpoint"`
+            User     string `json:"user"`
+            Id       string `json:"id"`
+        }{}
+
+        if err := json.NewDecoder(io.LimitReader(req.Body, cMaxPostedCommandSize)).Decode(body); err == nil {
+            token, user, id = body.Token, body.User, body.Id
+            if body.Endpoint != "" {
+                token = body.Endpoint
+            }
+        }
+    }
+
+    if token == "" && id != "" {
+        if !c.authorizeUser(w, req, user) {
+            return
+        }
+
+        if !c.pushNotifier.UnregisterDevice(user, id) {
+            fmt.Fprintf(w, "false")
+            return
+        }
+
+        fmt.Fprintf(w, "true")
+        return
+    }
+
+    if token == "" || !c.pushNotifier.Unregister(token) {
+        fmt.Fprintf(w, "false")
+        return
+    }
+
+    fmt.Fprintf(w, "true")
+}
+
+// authorizeUser checks that the request carries the API token of a live
+// connection currently holding nick user, it replies with an error if not
+func (c *ChatService) authorizeUser(w http.ResponseWriter, req *http.Request, user string) bool {
+    token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+    if token == "" || token == req.Header.Get("Authorization") {
+        http.Error(w, "Missing API token", http.StatusUnauthorized)
+        return false
+    }
+
+    c.Lock()
+    handler, ok := c.apiTokens[token]
+    c.Unlock()
+
+    if !ok {
+        http.Error(w, "Invalid API token", http.StatusUnauthorized)
+        return false
+    }
+
+    if nick, _ := c.nickRegistry.NickOf(handler.id); nick != user {
+        http.Error(w, "Not allowed for "+user, http.StatusForbidden)
+        return false
+    }
+
+    return true
+}
+
+func (c *ChatService) onGetPushDevices(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    if !c.authorizeUser(w, req, p.ByName("user")) {
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(c.pushNotifier.Devices(p.ByName("user")))
+}
+
+func (c *ChatService) onGetNotificationPrefs(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    if !c.authorizeUser(w, req, p.ByName("user")) {
+        return
+    }
+
+    prefs, err := c.prefsStore.Get(p.ByName("user"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(prefs)
+}
+
+func (c *ChatService) onSetNotificationPrefs(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    if !c.authorizeUser(w, req, p.ByName("user")) {
+        return
+    }
+
+    prefs := &NotificationPrefs{}
+    if err := json.NewDecoder(io.LimitReader(req.Body, cMaxPostedCommandSize)).Decode(prefs); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if err := prefs.Validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if prefs.MutedGroups == nil {
+        prefs.MutedGroups = []string{}
+    }
+
+    if err := c.prefsStore.Save(p.ByName("user"), prefs); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    fmt.Fprintf(w, "true")
+}
+
+func (c *ChatService) onGetVAPIDKey(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "public_key": c.pushNotifier.worker.VAPIDPublicKey(),
+    })
+}
+
+// parsePushRegistration accepts a browser PushSubscription JSON body or the
+// platform/token form values sent by native clients, both must name the user
+// (nick) being notified and may restrict mentions to a list of channels
+func parsePushRegistration(req *http.Request) (*PushRegistration, error) {
+    reg := &PushRegistration{}
+
+    if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+        body := &struct {
+            Platform string   `json:"platform"`
+            Token    string   `json:"token"`
+            Endpoint string   `json:"endpoint"`
+            User     string   `json:"user"`
+            Channels []string `json:"channels"`
+            Keys     struct {
+                P256dh string `json:"p256dh"`
+                Auth   string `json:"auth"`
+            } `json:"keys"`
+        }{}
+
+        if err := json.NewDecoder(io.LimitReader(req.Body, cMaxPostedCommandSize)).Decode(body); err != nil {
+            return nil, err
+        }
+
+        reg.User = body.User
+        reg.Channels = body.Channels
+        if body.Endpoint != "" {
+            reg.Subscription = raspush.Subscription{
+                Platform: raspush.PlatformWebPush,
+                Token:    body.Endpoint,
+                P256dh:   body.Keys.P256dh,
+                Auth:     body.Keys.Auth,
+            }
+        } else {
+            reg.Subscription = raspush.Subscription{
+                Platform: body.Platform,
+                Token:    body.Token,
+            }
+        }
+    } else {
+        reg.User = req.FormValue("user")
+        reg.Subscription = raspush.Subscription{
+            Platform: req.FormValue("platform"),
+            Token:    req.FormValue("token"),
+            P256dh:   req.FormValue("p256dh"),
+            Auth:     req.FormValue("auth"),
+        }
+
+        if channels := req.FormValue("channels"); channels != "" {
+            reg.Channels = strings.Split(channels, ",")
+        }
+    }
+
+    if reg.Token == "" {
+        return nil, errors.New("Missing push token")
+    }
+
+    if reg.User == "" {
+        return nil, errors.New("Missing push user")
+    }
+
+    if reg.Platform == "" {
+        reg.Platform = raspush.PlatformFCM
+    }
+
+    // The server POSTs to web push endpoints, so they can't point inside
+    if reg.Platform == raspush.PlatformWebPush {
+        if err := raspush.ValidateEndpoint(reg.Token); err != nil {
+            return nil, fmt.Errorf("Invalid web push endpoint %q", reg.Token)
+        }
+    }
+
+    return reg, nil
+}
+
+// parseHistoryTime reads unix milliseconds or an RFC 3339 timestamp
+func parseHistoryTime(value string) (*time.Time, error) {
+    if value == "" {
+        return nil, nil
+    }
+
+    if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+        t := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+        return &t, nil
+    }
+
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return nil, fmt.Errorf("Invalid time %q, use unix milliseconds or RFC 3339", value)
+    }
+
+    return &t, nil
+}
+
+// historyRange parses the optional since and until query parameters
+func historyRange(sinceValue, untilValue string) (since, until *time.Time, err error) {
+    if since, err = parseHistoryTime(sinceValue); err != nil {
+        return
+    }
+
+    if until, err = parseHistoryTime(untilValue); err != nil {
+        return
+    }
+
+    if since != nil && until != nil && until.Before(*since) {
+        err = errors.New("until is before since")
+    }
+
+    return
+}
+
+func (c *ChatService) onGetChatHistory(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    groupID := p.ByName("id")
+
+    queryParams := req.URL.Query()
+    var offset uint = 0
+    var limit uint = 20
+    startID := queryParams.Get("start_id")
+
+    if o, err := strconv.ParseUint(queryParams.Get("offset"), 10, 32); err == nil {
+        offset = uint(o)
+    }
+
+    if l, err := strconv.ParseUint(queryParams.Get("limit"), 10, 32); err == nil {
+        limit = uint(l)
+    }
+
+    since, until, err := historyRange(queryParams.Get("since"), queryParams.Get("until"))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(ErrorMessage{
+            Error: err.Error(),
+        })
+        return
+    }
+
+    var chatLog []IEventMessage
+    if since != nil || until != nil {
+        sinceId, untilId := uint64(0), ^uint64(0)
+        if since != nil {
+            sinceId = FirstSnowFlakeAt(*since)
+        }
+        if until != nil {
+            untilId = LastSnowFlakeAt(*until)
+        }
+
+        chatLog, err = c.chatStore.GetMessagesBetween(groupID, sinceId, untilId, offset, limit)
+    } else {
+        chatLog, err = c.chatStore.GetMessagesFor(groupID, startID, offset, limit)
+    }
+
+    if err == nil {
+        response := make(map[string]interface{})
+        response["limit"] = limit
+        response["offset"] = offset
+        response["messages"] = chatLog
+        response["start_id"] = startID
+        response["id"] = groupID
+        if since != nil {
+            response["since"] = since
+        }
+        if until != nil {
+            response["until"] = until
+        }
+        json.NewEncoder(w).Encode(response)
+    } else {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(ErrorMessage{
+            Error: err.Error(),
+        })
+    }
+}
+
+// onGetMentions lists the latest messages mentioning the user the caller is
+// authorized as
+func (c *ChatService) onGetMentions(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+    queryParams := req.URL.Query()
+    user := queryParams.Get("user")
+    var limit uint = 20
+
+    if l, err := strconv.ParseUint(queryParams.Get("limit"), 10, 32); err == nil {
+        limit = uint(l)
+    }
+
+    if user == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(ErrorMessage{
+            Error: "Missing user",
+        })
+        return
+    }
+
+    if !c.authorizeUser(w, req, user) {
+        return
+    }
+
+    ids, err := c.mentionStore.Recent(user, limit)
+    if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(ErrorMessage{
+            Error: err.Error(),
+        })
+        return
+    }
+
+    messages := make([]IEventMessage, 0, len(ids))
+    for _, id := range ids {
+        if msg, err := c.chatStore.GetMessage(id); err == nil && msg != nil {
+            messages = append(messages, msg)
+        }
+    }
+
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "user":     user,
+        "limit":    limit,
+        "messages": messages,
+    })
+}
+
+// onGetPresence answers with a user's presence, or the last time an
+// offline user was seen
+func (c *ChatService) onGetPresence(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    nick := p.ByName("nick")
+
+    presence := Presence{Status: ricaEvents.STATUS_OFFLINE}
+    if id, online := c.nickRegistry.IdOf(nick); online {
+        presence = c.presence.Get(id)
+    }
+
+    if presence.Status == ricaEvents.STATUS_OFFLINE {
+        lastSeen, ok := c.presenceStore.LastSeen(nick)
+        if !ok {
+            w.WriteHeader(http.StatusNotFound)
+            json.NewEncoder(w).Encode(ErrorMessage{
+                Error: "Unknown user",
+            })
+            return
+        }
+
+        presence.LastSeen = lastSeen.Unix()
+    }
+
+    response := make(map[string]interface{})
+    response["nick"] = nick
+    response["presence"] = presence
+    json.NewEncoder(w).Encode(response)
+}
+
+func (c *ChatService) onGetChatMessage(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+}
+
+// TODO: this code should be moved in a separate handler
+func (c *ChatService) onGetChannels(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+}
+
+// TODO: this code should be moved in a separate handler
+func (c *ChatService) onGetChannelInfo(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    groupID := p.ByName("id")
+
+    for key, val := range c.nickRegistry.GetMappingSnapshot() {
+        fmt.Fprintf(w, "%v => %v \n", key, val)
+    }
+
+    for uid, info := range c.groupInfo.GetAllInfoObjects(groupID) {
+        if inf, ok := info.(*userOutGoingInfo); ok {
+            fmt.Fprintf(w, "IP %v => %v \n", uid, inf.ip)
+        } else {
+            fmt.Fprintf(w, "Invalid %v => %v \n", uid, info)
+        }
+    }
+}
+
+func (c *ChatService) onGetStats(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "outbound": CurrentOutboundStats(),
+    })
+}
+
+func (c *ChatService) onBlackListUser(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+    userId := p.ByName("uid")
+    action := p.ByName("action")
+    if action == "off" {
+        c.blackList.Remove(userId)
+    } else {
+        c.blackList.Add(userId)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,70 @@
+package rica
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestAuthorizeUserNeedsLiveSessionOfUser(t *testing.T) {
+    nicks := NewNickRegistry()
+    h := &ChatHandler{id: "id-alice", apiToken: "token-alice"}
+    nicks.Register(h.id, "alice")
+
+    c := &ChatService{
+        nickRegistry: nicks,
+        apiTokens:    map[string]*ChatHandler{h.apiToken: h},
+    }
+
+    cases := []struct {
+        authorization string
+        user          string
+        status        int
+    }{
+        {"", "alice", http.StatusUnauthorized},
+        {"token-alice", "alice", http.StatusUnauthorized},
+        {"Bearer wrong", "alice", http.StatusUnauthorized},
+        {"Bearer token-alice", "bob", http.StatusForbidden},
+        {"Bearer token-alice", "alice", http.StatusOK},
+    }
+
+    for _, tc := range cases {
+        req := httptest.NewRequest("GET", "/chat/api/devices/"+tc.user, nil)
+        if tc.authorization != "" {
+            req.Header.Set("Authorization", tc.authorization)
+        }
+
+        w := httptest.NewRecorder()
+        if ok := c.authorizeUser(w, req, tc.user); ok != (tc.status == http.StatusOK) || w.Code != tc.status {
+            t.Errorf("%q for %s: authorized %v with status %d, want %d", tc.authorization, tc.user, ok, w.Code, tc.status)
+        }
+    }
+
+    // The token stops working for alice once the nick moves on
+    nicks.Unregister(h.id)
+    nicks.Register(h.id, "alice2")
+    req := httptest.NewRequest("GET", "/chat/api/devices/alice", nil)
+    req.Header.Set("Authorization", "Bearer token-alice")
+    if c.authorizeUser(httptest.NewRecorder(), req, "alice") {
+        t.Error("Token still authorizes alice after a nick change")
+    }
+}
+
+func TestMentionsNeedTokenOfUser(t *testing.T) {
+    nicks := NewNickRegistry()
+    h := &ChatHandler{id: "id-alice", apiToken: "token-alice"}
+    nicks.Register(h.id, "alice")
+
+    c := &ChatService{
+        nickRegistry: nicks,
+        apiTokens:    map[string]*ChatHandler{h.apiToken: h},
+    }
+
+    req := httptest.NewRequest("GET", "/chat/api/mentions?user=bob", nil)
+    req.Header.Set("Authorization", "Bearer token-alice")
+    w := httptest.NewRecorder()
+    c.onGetMentions(w, req, nil)
+    if w.Code != http.StatusForbidden {
+        t.Fatalf("Read bob's mentions as alice, status %d", w.Code)
+    }
+}
@@ -0,0 +1,152 @@
+package rica
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "reflect"
+
+    "sibte.so/rica/consts"
+)
+
+// ClusterEnvelope carries a message between nodes. Messages for a group
+// reach its members on every node, messages with To only that user id
+type ClusterEnvelope struct {
+    Node    string          `json:"node"`
+    Group   string          `json:"group"`
+    To      string          `json:"to,omitempty"`
+    Type    string          `json:"type"`
+    Message json.RawMessage `json:"msg"`
+}
+
+// ClusterBus shares published messages, nicks and group membership between
+// the nodes of a cluster
+type ClusterBus interface {
+    // Publish sends env to every other node
+    Publish(env *ClusterEnvelope) error
+    // Subscribe calls deliver for published envelopes, including the node's own
+    Subscribe(deliver func(*ClusterEnvelope)) error
+    // ClaimNick reserves nick for id cluster wide, false if someone holds it
+    ClaimNick(nick, id string) (bool, error)
+    // ReleaseNick frees nick if id still holds it
+    ReleaseNick(nick, id string) error
+    // OnNickLost sets fn to be called when a claim of this node expired and
+    // the nick went to someone else
+    OnNickLost(fn func(nick, id string))
+    NickOwner(nick string) (string, bool)
+    NickOf(id string) (string, bool)
+    Join(group, id string) error
+    Leave(group, id string) error
+    // Members returns the ids in group on every node
+    Members(group string) ([]string, error)
+    // ClaimWorkerId reserves a snowflake worker id for owner, false if
+    // another node holds it. The claim is released on Close
+    ClaimWorkerId(workerId uint32, owner string) (bool, error)
+    Close() error
+}
+
+// Message types that can cross the bus, by type name
+var pClusterMessageTypes = make(map[string]reflect.Type)
+
+func init() {
+    for _, msg := range []IEventMessage{
+        &ChatMessage{},
+        &RecipientMessage{},
+        &RecipientContentMessage{},
+        &NickMessage{},
+        &StringMessage{},
+        &ErrorMessage{},
+        &PresenceMessage{},
+    } {
+        t := reflect.TypeOf(msg).Elem()
+        pClusterMessageTypes[t.Name()] = t
+    }
+}
+
+func newClusterEnvelope(node, group, to string, msg IEventMessage) (*ClusterEnvelope, error) {
+    t := reflect.TypeOf(msg).Elem()
+    if _, ok := pClusterMessageTypes[t.Name()]; !ok {
+        return nil, fmt.Errorf("Message type %s can't be sent to other nodes", t.Name())
+    }
+
+    b, err := json.Marshal(msg)
+    if err != nil {
+        return nil, err
+    }
+
+    return &ClusterEnvelope{
+        Node:    node,
+        Group:   group,
+        To:      to,
+        Type:    t.Name(),
+        Message: b,
+    }, nil
+}
+
+// decodeClusterMessage restores the typed message carried by env
+func decodeClusterMessage(env *ClusterEnvelope) (IEventMessage, error) {
+    t, ok := pClusterMessageTypes[env.Type]
+    if !ok {
+        return nil, fmt.Errorf("Unknown cluster message type %s", env.Type)
+    }
+
+    msg := reflect.New(t).Interface().(IEventMessage)
+    if err := json.Unmarshal(env.Message, msg); err != nil {
+        return nil, err
+    }
+
+    // Nick changes are published wrapped, transports expect the inner message typed
+    if content, ok := msg.(*RecipientContentMessage); ok && content.EventName == ricaEvents.MEMBER_NICK_SET_REPLY {
+        nickMsg := &NickMessage{}
+        b, _ := json.Marshal(content.Message)
+        if err := json.Unmarshal(b, nickMsg); err != nil {
+            return nil, err
+        }
+        content.Message = nickMsg
+    }
+
+    return msg, nil
+}
+
+// clusterNode is this process's link to the cluster, a nil node means the
+// server runs alone and every method is a no-op
+type clusterNode struct {
+    id  string
+    bus ClusterBus
+}
+
+// publish sends msg to group members on other nodes, or only to user id to
+func (n *clusterNode) publish(group, to string, msg IEventMessage) {
+    if n == nil {
+        return
+    }
+
+    env, err := newClusterEnvelope(n.id, group, to, msg)
+    if err == nil {
+        err = n.bus.Publish(env)
+    }
+
+    if err != nil {
+        log.Println("Unable to publish to cluster", group, err)
+    }
+}
+
+func (n *clusterNode) join(group, id string) {
+    if n == nil {
+        return
+    }
+
+    if err := n.bus.Join(group, id); err != nil {
+        log.Println("Unable to join cluster group", group, err)
+    }
+}
+
+func (n *clusterNode) leave(group, id string) {
+    if n == nil {
+        return
+    }
+
+    if err := n.bus.Leave(group, id); err != nil {
+        log.Println("Unable to leave cluster group", group, err)
+    }
+}
@@ -0,0 +1,46 @@
+package rica
+
+import (
+    "sync"
+)
+
+// ConnectionLimiter caps concurrent connections per IP, a limit of 0 or
+// less allows any number
+type ConnectionLimiter struct {
+    sync.Mutex
+    limit  int
+    counts map[string]int
+}
+
+func NewConnectionLimiter(limit int) *ConnectionLimiter {
+    return &ConnectionLimiter{
+        limit:  limit,
+        counts: make(map[string]int),
+    }
+}
+
+// TryAcquire reserves a connection slot for ip, every successful call must
+// be paired with Release
+func (l *ConnectionLimiter) TryAcquire(ip string) bool {
+    l.Lock()
+    defer l.Unlock()
+
+    if l.limit > 0 && l.counts[ip] >= l.limit {
+        return false
+    }
+
+    l.counts[ip]++
+    return true
+}
+
+func (l *ConnectionLimiter) Release(ip string) {
+    l.Lock()
+    defer l.Unlock()
+
+    if l.counts[ip] <= 1 {
+        delete(l.counts, ip)
+        return
+    }
+
+    l.counts[ip]--
+}
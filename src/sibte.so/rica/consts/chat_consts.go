@@ -0,0 +1,58 @@
+package ricaEvents
+
+const (
+    FROM_SERVER = "SERVER"
+
+    PING_COMMAND         = "ping"
+    HELLO_COMMAND        = "hello"
+    JOIN_GROUP_COMMAND   = "join-group"
+    LEAVE_GROUP_COMMAND  = "leave-group"
+    SET_NICK_COMMAND     = "set-nick"
+    SEND_MSG_COMMAND     = "send-msg"
+    LIST_MEMBERS_COMMAND = "list-group"
+    SEND_RAW_MSG_COMMAND = "send-raw-msg"
+    RESUME_COMMAND       = "resume-session"
+    SET_STATUS_COMMAND   = "set-status"
+
+    PING_REPLY            = "pong"
+    JOIN_GROUP_REPLY      = "group-join"
+    LEAVE_GROUP_REPLY     = "group-leave"
+    SET_NICK_REPLY        = "nick-set"
+    MEMBER_NICK_SET_REPLY = "member-nick-set"
+    NEW_MSG_REPLY         = "new-msg"
+    NEW_RAW_MSG_REPLY     = "new-raw-msg"
+    LIST_MEMBERS_REPLY    = "group-list"
+    GROUP_MSG_REPLY       = "group-message"
+    DIRECT_MSG_REPLY      = "direct-message"
+    MENTION_REPLY         = "mention"
+    ERROR_MSG_REPLY       = "error-msg"
+    SERVER_GOING_AWAY     = "server-going-away"
+    SESSION_RESUMED_REPLY = "session-resumed"
+    PRESENCE_REPLY        = "presence"
+    HELLO_REPLY           = "server-hello"
+
+    STATUS_ONLINE    = "online"
+    STATUS_AWAY      = "away"
+    STATUS_BUSY      = "busy"
+    STATUS_INVISIBLE = "invisible"
+    STATUS_OFFLINE   = "offline"
+
+    ERROR_INVALID_MSGTYPE_ERR = "Chat handler received invalid message type"
+
+    ERROR_RATE_LIMITED        = "rate_limited"
+    ERROR_MUTED               = "muted"
+    ERROR_FLOOD_DISCONNECT    = "flood_disconnect"
+    ERROR_RESUME_FAILED       = "resume_failed"
+    ERROR_INVALID_STATUS      = "invalid_status"
+    ERROR_INVALID_GROUP       = "invalid_group"
+    ERROR_UNKNOWN_COMMAND     = "unknown_command"
+    ERROR_INVALID_MESSAGE     = "invalid_message"
+    ERROR_UNSUPPORTED_VERSION = "unsupported_version"
+
+    FEATURE_UPLOADS  = "uploads"
+    FEATURE_GIFS     = "gifs"
+    FEATURE_PUSH     = "push"
+    FEATURE_PRESENCE = "presence"
+    FEATURE_MENTIONS = "mentions"
+    FEATURE_RESUME   = "resume"
+)
@@ -0,0 +1,129 @@
+package rica
+
+import (
+    "sync"
+    "time"
+
+    "sibte.so/rasconfig"
+    "sibte.so/rasrate"
+)
+
+const (
+    // Violations within cFloodViolationWindow only earn a warning up to
+    // cFloodWarnings, then the connection gets muted and beyond
+    // cFloodDisconnectAfter it is dropped
+    cFloodWarnings        = 3
+    cFloodDisconnectAfter = 10
+    cFloodViolationWindow = time.Minute
+    cFloodMuteDuration    = 30 * time.Second
+)
+
+type floodPenalty int
+
+const (
+    floodWarning floodPenalty = iota
+    floodMute
+    floodDisconnect
+)
+
+// FloodControl holds the rate limiters of every action on every scope
+// (connection, user and IP) configured under rate_limits
+type FloodControl struct {
+    sync.RWMutex
+    limiters map[string]map[string]*rasrate.Limiter
+}
+
+func NewFloodControl(limits map[string]map[string]rasconfig.RateLimit) *FloodControl {
+    ret := &FloodControl{}
+    ret.Update(limits)
+    return ret
+}
+
+// Update replaces every limiter, buckets start out full again
+func (f *FloodControl) Update(limits map[string]map[string]rasconfig.RateLimit) {
+    limiters := make(map[string]map[string]*rasrate.Limiter)
+    for action, scopes := range limits {
+        limiters[action] = make(map[string]*rasrate.Limiter)
+        for scope, limit := range scopes {
+            limiters[action][scope] = rasrate.NewLimiter(limit.Rate, limit.Burst)
+        }
+    }
+
+    f.Lock()
+    defer f.Unlock()
+
+    f.limiters = limiters
+}
+
+// Allow takes a token for action from the connection, user and IP buckets,
+// scopes without a limiter always allow. When one scope denies, the tokens
+// already taken from the others are given back
+func (f *FloodControl) Allow(action, connection, user, ip string) bool {
+    f.RLock()
+    scopes := f.limiters[action]
+    f.RUnlock()
+
+    checks := []struct {
+        limiter *rasrate.Limiter
+        key     string
+    }{
+        {scopes[rasconfig.RateScopeConnection], connection},
+        {scopes[rasconfig.RateScopeUser], user},
+        {scopes[rasconfig.RateScopeIP], ip},
+    }
+
+    for i, c := range checks {
+        if c.limiter.Allow(c.key) {
+            continue
+        }
+
+        for _, taken := range checks[:i] {
+            taken.limiter.Refund(taken.key)
+        }
+        return false
+    }
+
+    return true
+}
+
+// ChangeUser carries the user buckets of every action over to a new nick,
+// changing nicks doesn't reset the user limits
+func (f *FloodControl) ChangeUser(oldUser, newUser string) {
+    f.RLock()
+    defer f.RUnlock()
+
+    for _, scopes := range f.limiters {
+        scopes[rasconfig.RateScopeUser].Carry(oldUser, newUser)
+    }
+}
+
+// floodState tracks the violations of a single connection
+type floodState struct {
+    violations    int
+    lastViolation time.Time
+    mutedUntil    time.Time
+}
+
+func (s *floodState) muted(now time.Time) bool {
+    return now.Before(s.mutedUntil)
+}
+
+// violate records a rate limit violation and returns the penalty it earns
+func (s *floodState) violate(now time.Time) floodPenalty {
+    if now.Sub(s.lastViolation) > cFloodViolationWindow {
+        s.violations = 0
+    }
+
+    s.violations++
+    s.lastViolation = now
+
+    switch {
+    case s.violations > cFloodDisconnectAfter:
+        return floodDisconnect
+    case s.violations > cFloodWarnings:
+        s.mutedUntil = now.Add(cFloodMuteDuration)
+        return floodMute
+    }
+
+    return floodWarning
+}
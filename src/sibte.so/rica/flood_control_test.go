@@ -0,0 +1,57 @@
+package rica
+
+import (
+    "testing"
+
+    "sibte.so/rasconfig"
+)
+
+// Refills are slow enough to not matter within a test
+func newTestFloodControl(connectionBurst, userBurst int) *FloodControl {
+    return NewFloodControl(map[string]map[string]rasconfig.RateLimit{
+        rasconfig.RateLimitMessage: {
+            rasconfig.RateScopeConnection: {Rate: 0.001, Burst: connectionBurst},
+            rasconfig.RateScopeUser:       {Rate: 0.001, Burst: userBurst},
+        },
+    })
+}
+
+func TestFloodControlDenyKeepsOtherBuckets(t *testing.T) {
+    f := newTestFloodControl(3, 1)
+
+    if !f.Allow(rasconfig.RateLimitMessage, "conn", "alice", "ip") {
+        t.Fatal("First message denied")
+    }
+
+    // The user bucket is empty, the connection must not pay for the denials
+    for i := 0; i < 5; i++ {
+        if f.Allow(rasconfig.RateLimitMessage, "conn", "alice", "ip") {
+            t.Fatal("Message allowed past the user limit")
+        }
+    }
+
+    for i := 0; i < 2; i++ {
+        if !f.Allow(rasconfig.RateLimitMessage, "conn", "user"+string(rune('a'+i)), "ip") {
+            t.Fatalf("Connection bucket drained by denied messages after %d", i)
+        }
+    }
+}
+
+func TestFloodControlNickChangeKeepsUserBucket(t *testing.T) {
+    f := newTestFloodControl(100, 2)
+
+    for i := 0; i < 2; i++ {
+        f.Allow(rasconfig.RateLimitMessage, "conn", "alice", "ip")
+    }
+
+    f.ChangeUser("alice", "alice_")
+    if f.Allow(rasconfig.RateLimitMessage, "conn", "alice_", "ip") {
+        t.Error("New nick started with a full bucket")
+    }
+
+    // Changing to a nick with a fuller bucket doesn't refill it either
+    f.ChangeUser("alice_", "alice")
+    if f.Allow(rasconfig.RateLimitMessage, "conn", "alice", "ip") {
+        t.Error("Changing back refilled the bucket")
+    }
+}
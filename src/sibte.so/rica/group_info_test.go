@@ -0,0 +1,56 @@
+package rica
+
+import (
+    "fmt"
+    "strconv"
+    "testing"
+)
+
+// populatedGroupInfo returns groups with members users each
+func populatedGroupInfo(groups, members int) GroupInfoManager {
+    info := NewInMemoryGroupInfo()
+    for g := 0; g < groups; g++ {
+        for u := 0; u < members; u++ {
+            info.AddUser(fmt.Sprintf("group-%d", g), strconv.Itoa(u), struct{}{})
+        }
+    }
+
+    return info
+}
+
+func BenchmarkGroupInfoAddRemoveUser(b *testing.B) {
+    info := populatedGroupInfo(10, 100)
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        user := strconv.Itoa(1000 + i%1000)
+        info.AddUser("group-0", user, struct{}{})
+        info.RemoveUser("group-0", user)
+    }
+}
+
+func BenchmarkGroupInfoGetUsers(b *testing.B) {
+    for _, members := range []int{10, 100, 1000} {
+        b.Run(strconv.Itoa(members), func(b *testing.B) {
+            info := populatedGroupInfo(10, members)
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                info.GetUsers("group-0")
+            }
+        })
+    }
+}
+
+func BenchmarkGroupInfoGetUserInfoObject(b *testing.B) {
+    info := populatedGroupInfo(10, 1000)
+
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        i := 0
+        for pb.Next() {
+            info.GetUserInfoObject("group-0", strconv.Itoa(i%1000))
+            i++
+        }
+    })
+}
@@ -0,0 +1,204 @@
+package rica
+
+import (
+    "log"
+    "sync"
+)
+
+// InProcessHub links ChatServices running in one process as if they were
+// cluster nodes, meant for tests
+type InProcessHub struct {
+    sync.Mutex
+    nicks   map[string]string
+    ids     map[string]string
+    groups  map[string]map[string]struct{}
+    workers map[uint32]string
+    buses   map[*inProcessBus]struct{}
+}
+
+func NewInProcessHub() *InProcessHub {
+    return &InProcessHub{
+        nicks:   make(map[string]string),
+        ids:     make(map[string]string),
+        groups:  make(map[string]map[string]struct{}),
+        workers: make(map[uint32]string),
+        buses:   make(map[*inProcessBus]struct{}),
+    }
+}
+
+// Bus returns a new node's connection to the hub
+func (hub *InProcessHub) Bus() ClusterBus {
+    b := &inProcessBus{
+        hub:   hub,
+        queue: make(chan *ClusterEnvelope, cDefaultOutboundQueueSize),
+    }
+
+    hub.Lock()
+    hub.buses[b] = struct{}{}
+    hub.Unlock()
+    return b
+}
+
+type inProcessBus struct {
+    sync.Mutex
+    hub      *InProcessHub
+    queue    chan *ClusterEnvelope
+    closed   bool
+    workerId *uint32
+}
+
+func (b *inProcessBus) Publish(env *ClusterEnvelope) error {
+    b.hub.Lock()
+    others := make([]*inProcessBus, 0, len(b.hub.buses))
+    for other := range b.hub.buses {
+        if other != b {
+            others = append(others, other)
+        }
+    }
+    b.hub.Unlock()
+
+    for _, other := range others {
+        other.enqueue(env)
+    }
+
+    return nil
+}
+
+// enqueue drops env when the node's queue is full rather than block the
+// publisher while holding the lock Close needs
+func (b *inProcessBus) enqueue(env *ClusterEnvelope) {
+    b.Lock()
+    defer b.Unlock()
+
+    if b.closed {
+        return
+    }
+
+    select {
+    case b.queue <- env:
+    default:
+        log.Println("Cluster queue full, dropping", env.Type, "for", env.Group)
+    }
+}
+
+// Subscribe delivers on a single goroutine so every node sees messages in
+// the order they were published
+func (b *inProcessBus) Subscribe(deliver func(*ClusterEnvelope)) error {
+    go func() {
+        for env := range b.queue {
+            deliver(env)
+        }
+    }()
+
+    return nil
+}
+
+func (b *inProcessBus) ClaimNick(nick, id string) (bool, error) {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    if owner, ok := b.hub.nicks[nick]; ok && owner != id {
+        return false, nil
+    }
+
+    b.hub.nicks[nick] = id
+    b.hub.ids[id] = nick
+    return true, nil
+}
+
+func (b *inProcessBus) ReleaseNick(nick, id string) error {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    if b.hub.nicks[nick] == id {
+        delete(b.hub.nicks, nick)
+        if b.hub.ids[id] == nick {
+            delete(b.hub.ids, id)
+        }
+    }
+
+    return nil
+}
+
+// OnNickLost is never called, hub claims don't expire
+func (b *inProcessBus) OnNickLost(fn func(nick, id string)) {
+}
+
+func (b *inProcessBus) NickOwner(nick string) (string, bool) {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    id, ok := b.hub.nicks[nick]
+    return id, ok
+}
+
+func (b *inProcessBus) NickOf(id string) (string, bool) {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    nick, ok := b.hub.ids[id]
+    return nick, ok
+}
+
+func (b *inProcessBus) Join(group, id string) error {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    if b.hub.groups[group] == nil {
+        b.hub.groups[group] = make(map[string]struct{})
+    }
+    b.hub.groups[group][id] = struct{}{}
+    return nil
+}
+
+func (b *inProcessBus) Leave(group, id string) error {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    delete(b.hub.groups[group], id)
+    return nil
+}
+
+func (b *inProcessBus) Members(group string) ([]string, error) {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    ret := make([]string, 0, len(b.hub.groups[group]))
+    for id := range b.hub.groups[group] {
+        ret = append(ret, id)
+    }
+
+    return ret, nil
+}
+
+func (b *inProcessBus) ClaimWorkerId(workerId uint32, owner string) (bool, error) {
+    b.hub.Lock()
+    defer b.hub.Unlock()
+
+    if holder, ok := b.hub.workers[workerId]; ok && holder != owner {
+        return false, nil
+    }
+
+    b.hub.workers[workerId] = owner
+    b.workerId = &workerId
+    return true, nil
+}
+
+func (b *inProcessBus) Close() error {
+    b.hub.Lock()
+    delete(b.hub.buses, b)
+    if b.workerId != nil {
+        delete(b.hub.workers, *b.workerId)
+    }
+    b.hub.Unlock()
+
+    b.Lock()
+    defer b.Unlock()
+
+    if !b.closed {
+        b.closed = true
+        close(b.queue)
+    }
+
+    return nil
+}
@@ -0,0 +1,103 @@
+package rica
+
+import (
+    "testing"
+    "time"
+)
+
+func TestInProcessBusClaimAndRelease(t *testing.T) {
+    hub := NewInProcessHub()
+    a, b := hub.Bus(), hub.Bus()
+    defer a.Close()
+    defer b.Close()
+
+    if ok, err := a.ClaimNick("alice", "id1"); !ok || err != nil {
+        t.Fatalf("Claim failed %v %v", ok, err)
+    }
+
+    if ok, _ := b.ClaimNick("alice", "id2"); ok {
+        t.Fatal("Nick claimed twice across nodes")
+    }
+
+    if id, ok := b.NickOwner("alice"); !ok || id != "id1" {
+        t.Fatalf("Owner %q %v", id, ok)
+    }
+
+    if nick, ok := b.NickOf("id1"); !ok || nick != "alice" {
+        t.Fatalf("Nick of id1 %q %v", nick, ok)
+    }
+
+    // Only the holder can release
+    b.ReleaseNick("alice", "id2")
+    if _, ok := a.NickOwner("alice"); !ok {
+        t.Fatal("Nick released by someone else")
+    }
+
+    a.ReleaseNick("alice", "id1")
+    if ok, _ := b.ClaimNick("alice", "id2"); !ok {
+        t.Fatal("Released nick can't be claimed")
+    }
+}
+
+func TestInProcessBusWorkerIdFreedOnClose(t *testing.T) {
+    hub := NewInProcessHub()
+    a, b := hub.Bus(), hub.Bus()
+    defer b.Close()
+
+    if ok, _ := a.ClaimWorkerId(1, "a"); !ok {
+        t.Fatal("Worker id not claimed")
+    }
+
+    if ok, _ := b.ClaimWorkerId(1, "b"); ok {
+        t.Fatal("Worker id claimed twice")
+    }
+
+    a.Close()
+    if ok, _ := b.ClaimWorkerId(1, "b"); !ok {
+        t.Fatal("Worker id still held after Close")
+    }
+}
+
+func TestInProcessBusPublishReachesOtherNodes(t *testing.T) {
+    hub := NewInProcessHub()
+    a, b := hub.Bus(), hub.Bus()
+    defer a.Close()
+    defer b.Close()
+
+    received := make(chan *ClusterEnvelope, 1)
+    b.Subscribe(func(env *ClusterEnvelope) {
+        received <- env
+    })
+
+    a.Publish(&ClusterEnvelope{Node: "a", Group: "lounge", Type: "ChatMessage"})
+    select {
+    case env := <-received:
+        if env.Node != "a" || env.Group != "lounge" {
+            t.Fatalf("Unexpected envelope %#v", env)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("Envelope not delivered")
+    }
+}
+
+// A node that stops draining its queue must not block publishers or Close
+func TestInProcessBusFullQueueDoesNotBlock(t *testing.T) {
+    hub := NewInProcessHub()
+    a, b := hub.Bus(), hub.Bus()
+    defer a.Close()
+
+    done := make(chan struct{})
+    go func() {
+        for i := 0; i <= cDefaultOutboundQueueSize; i++ {
+            a.Publish(&ClusterEnvelope{Node: "a", Group: "lounge", Type: "ChatMessage"})
+        }
+        b.Close()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+    case <-time.After(2 * time.Second):
+        t.Fatal("Publish blocked on a full queue")
+    }
+}
@@ -0,0 +1,610 @@
+package rica
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "sibte.so/rica/consts"
+)
+
+const (
+    cIRCServerName   = "rica"
+    cIRCMaxLineSize  = 4096
+    cIRCWriteTimeout = 10 * time.Second
+    cIRCReadTimeout  = 60 * time.Second
+)
+
+// IRCMessageTransport speaks a subset of the IRC client protocol on a plain
+// TCP connection and translates it to and from chat handler events
+type IRCMessageTransport struct {
+    sync.Mutex
+    connection    net.Conn
+    writeLock     *sync.Mutex
+    lines         chan string
+    commands      chan IEventMessage
+    done          chan struct{}
+    closeOnce     sync.Once
+    readErr       error
+    nick          string
+    requestedNick string
+    gotUser       bool
+    gotInitial    bool
+    registered    bool
+    motd          string
+    lastNickMsgId uint64
+    channels      map[string]bool
+}
+
+func NewIRCMessageTransport(conn net.Conn) *IRCMessageTransport {
+    t := &IRCMessageTransport{
+        connection: conn,
+        writeLock:  &sync.Mutex{},
+        lines:      make(chan string, 8),
+        commands:   make(chan IEventMessage, 8),
+        done:       make(chan struct{}),
+        channels:   make(map[string]bool),
+    }
+
+    go t.lineReaderLoop()
+    return t
+}
+
+func (h *IRCMessageTransport) lineReaderLoop() {
+    defer close(h.lines)
+
+    scanner := bufio.NewScanner(h.connection)
+    scanner.Buffer(make([]byte, 512), cIRCMaxLineSize)
+    for {
+        // Clients answer our PINGs, a connection silent for longer is dead
+        h.connection.SetReadDeadline(time.Now().Add(cIRCReadTimeout))
+        if !scanner.Scan() {
+            break
+        }
+
+        // Nobody reads lines anymore once the transport is closed
+        select {
+        case h.lines <- strings.TrimRight(scanner.Text(), "\r"):
+        case <-h.done:
+            h.Lock()
+            h.readErr = io.ErrClosedPipe
+            h.Unlock()
+            return
+        }
+    }
+
+    h.Lock()
+    h.readErr = scanner.Err()
+    if h.readErr == nil {
+        h.readErr = io.EOF
+    }
+    h.Unlock()
+}
+
+func (h *IRCMessageTransport) ReadMessage() (IEventMessage, error) {
+    for {
+        select {
+        case cmd := <-h.commands:
+            return cmd, nil
+        case line, ok := <-h.lines:
+            if !ok {
+                h.Lock()
+                err := h.readErr
+                h.Unlock()
+                return nil, err
+            }
+
+            msg, err := h.translateLine(line)
+            if err != nil {
+                return nil, err
+            }
+
+            if msg != nil {
+                return msg, nil
+            }
+        }
+    }
+}
+
+// translateLine handles protocol level commands in place and returns the
+// chat event for commands that need to go through the chat handler
+func (h *IRCMessageTransport) translateLine(line string) (IEventMessage, error) {
+    cmd, params := parseIRCLine(line)
+    if cmd == "" {
+        return nil, nil
+    }
+
+    switch cmd {
+    case "CAP":
+        if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+            h.writeLine("CAP * LS :")
+        }
+        return nil, nil
+    case "PING":
+        h.writeLine(":%s PONG %s :%s", cIRCServerName, cIRCServerName, strings.Join(params, " "))
+        return nil, nil
+    case "PONG":
+        return ircPong(params), nil
+    case "QUIT":
+        h.writeLine("ERROR :Closing link")
+        h.connection.Close()
+        return nil, io.EOF
+    case "NICK":
+        return h.onNick(params), nil
+    case "USER":
+        h.Lock()
+        h.gotUser = true
+        h.Unlock()
+        h.tryCompleteRegistration()
+        return nil, nil
+    }
+
+    h.Lock()
+    registered := h.registered
+    h.Unlock()
+    if !registered {
+        h.writeReply("451", ":You have not registered")
+        return nil, nil
+    }
+
+    switch cmd {
+    case "JOIN":
+        return h.onChannelListCommand(cmd, params, ricaEvents.JOIN_GROUP_COMMAND)
+    case "PART":
+        return h.onChannelListCommand(cmd, params, ricaEvents.LEAVE_GROUP_COMMAND)
+    case "NAMES":
+        return h.onChannelListCommand(cmd, params, ricaEvents.LIST_MEMBERS_COMMAND)
+    case "PRIVMSG":
+        return h.onPrivMsg(params), nil
+    case "TOPIC":
+        h.onTopic(params)
+        return nil, nil
+    }
+
+    h.writeReply("421", "%s :Unknown command", cmd)
+    return nil, nil
+}
+
+func (h *IRCMessageTransport) onNick(params []string) IEventMessage {
+    if len(params) == 0 || params[0] == "" {
+        h.writeReply("431", ":No nickname given")
+        return nil
+    }
+
+    nick := params[0]
+    if invalidAliasRegex != nil && (invalidAliasRegex.MatchString(nick) || len(nick) > cMaxNickLength) {
+        h.writeReply("432", "%s :Erroneous nickname", nick)
+        return nil
+    }
+
+    h.Lock()
+    registered := h.registered
+    h.requestedNick = nick
+    h.Unlock()
+
+    if !registered {
+        h.tryCompleteRegistration()
+        return nil
+    }
+
+    return &StringMessage{
+        BaseMessage: BaseMessage{EventName: ricaEvents.SET_NICK_COMMAND},
+        Message:     nick,
+    }
+}
+
+func (h *IRCMessageTransport) onChannelListCommand(cmd string, params []string, event string) (IEventMessage, error) {
+    if len(params) == 0 {
+        h.writeReply("461", "%s :Not enough parameters", cmd)
+        return nil, nil
+    }
+
+    channels := strings.Split(params[0], ",")
+    for _, channel := range channels {
+        if !isIRCChannel(channel) {
+            h.writeReply("403", "%s :No such channel", channel)
+            return nil, nil
+        }
+    }
+
+    // Queue up every channel after the first so that each one is handled
+    for _, channel := range channels[1:] {
+        h.queueCommand(&StringMessage{
+            BaseMessage: BaseMessage{EventName: event},
+            Message:     ircChannelToGroup(channel),
+        })
+    }
+
+    return &StringMessage{
+        BaseMessage: BaseMessage{EventName: event},
+        Message:     ircChannelToGroup(channels[0]),
+    }, nil
+}
+
+func (h *IRCMessageTransport) onPrivMsg(params []string) IEventMessage {
+    if len(params) < 2 {
+        h.writeReply("412", ":No text to send")
+        return nil
+    }
+
+    // Private messages to a nick are the chat's @nick direct messages
+    to := "@" + params[0]
+    if isIRCChannel(params[0]) {
+        to = ircChannelToGroup(params[0])
+    }
+
+    return &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.SEND_MSG_COMMAND},
+            To:          to,
+        },
+        Message: params[1],
+    }
+}
+
+func (h *IRCMessageTransport) onTopic(params []string) {
+    if len(params) == 0 {
+        h.writeReply("461", "TOPIC :Not enough parameters")
+        return
+    }
+
+    if len(params) > 1 {
+        h.writeReply("482", "%s :Topics can not be changed", params[0])
+        return
+    }
+
+    h.writeReply("331", "%s :No topic is set", params[0])
+}
+
+func (h *IRCMessageTransport) queueCommand(msg IEventMessage) {
+    select {
+    case h.commands <- msg:
+    default:
+        h.writeLine(":%s NOTICE %s :Too many pending commands", cIRCServerName, h.currentNick())
+    }
+}
+
+// tryCompleteRegistration sends the welcome burst once the client has sent
+// NICK and USER and the chat handler has assigned its initial identity
+func (h *IRCMessageTransport) tryCompleteRegistration() {
+    h.Lock()
+    if h.registered || !h.gotUser || !h.gotInitial || h.requestedNick == "" {
+        h.Unlock()
+        return
+    }
+
+    h.registered = true
+    nick := h.nick
+    requested := h.requestedNick
+    motd := h.motd
+    h.Unlock()
+
+    h.writeReply("001", ":Welcome to the Raspchat IRC gateway %s", nick)
+    h.writeReply("002", ":Your host is %s", cIRCServerName)
+    h.writeReply("003", ":This server was created for raspchat")
+    h.writeReply("375", ":- %s Message of the day -", cIRCServerName)
+    for _, line := range ircLines(motd) {
+        if strings.TrimSpace(line) != "" {
+            h.writeReply("372", ":- %s", line)
+        }
+    }
+    h.writeReply("376", ":End of MOTD command")
+
+    if requested != nick {
+        h.queueCommand(&StringMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.SET_NICK_COMMAND},
+            Message:     requested,
+        })
+    }
+}
+
+func (h *IRCMessageTransport) WriteMessage(id uint64, msg IEventMessage) error {
+    switch v := msg.(type) {
+    case *StringMessage:
+        return h.writeStringMessage(v)
+    case *NickMessage:
+        return h.writeNickMessage(v)
+    case *ChatMessage:
+        return h.writeChatMessage(v)
+    case *GroupListMessage:
+        return h.writeRecipientContentMessage(&v.RecipientContentMessage)
+    case *RecipientContentMessage:
+        return h.writeRecipientContentMessage(v)
+    case *RecipientMessage:
+        return h.writeRecipientMessage(v)
+    case *PingMessage:
+        return h.writeLine("PING :%d", v.Type)
+    case *ErrorMessage:
+        return h.writeLine(":%s NOTICE %s :%s", cIRCServerName, h.currentNick(), v.Error)
+    }
+
+    return nil
+}
+
+func (h *IRCMessageTransport) writeStringMessage(msg *StringMessage) error {
+    if msg.EventName != ricaEvents.FROM_SERVER {
+        return nil
+    }
+
+    h.Lock()
+    registered := h.registered
+    if !registered {
+        h.motd = msg.Message
+    }
+    h.Unlock()
+
+    if !registered {
+        return nil
+    }
+
+    for _, line := range ircLines(msg.Message) {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+
+        if err := h.writeLine(":%s NOTICE %s :%s", cIRCServerName, h.currentNick(), line); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (h *IRCMessageTransport) writeNickMessage(msg *NickMessage) error {
+    h.Lock()
+    if !h.registered {
+        h.nick = msg.NewNick
+        h.gotInitial = true
+        h.Unlock()
+        h.tryCompleteRegistration()
+        return nil
+    }
+
+    h.nick = msg.NewNick
+    h.lastNickMsgId = msg.Id
+    h.Unlock()
+
+    if msg.OldNick == msg.NewNick {
+        return nil
+    }
+
+    return h.writeLine(":%s NICK :%s", ircUserPrefix(msg.OldNick), msg.NewNick)
+}
+
+func (h *IRCMessageTransport) writeChatMessage(msg *ChatMessage) error {
+    if msg.To == ricaEvents.FROM_SERVER {
+        return nil
+    }
+
+    // IRC clients echo their own messages locally
+    nick := h.currentNick()
+    if msg.From == nick {
+        return nil
+    }
+
+    switch msg.EventName {
+    case ricaEvents.GROUP_MSG_REPLY:
+        return h.writePrivMsg(msg.From, ircGroupToChannel(msg.To), "", msg.Message)
+    case ricaEvents.DIRECT_MSG_REPLY:
+        return h.writePrivMsg(msg.From, nick, "", msg.Message)
+    case ricaEvents.MENTION_REPLY:
+        // Members of the channel already got the message itself
+        h.Lock()
+        joined := h.channels[msg.To]
+        h.Unlock()
+
+        if !joined {
+            return h.writePrivMsg(msg.From, nick, ircGroupToChannel(msg.To)+": ", msg.Message)
+        }
+    }
+
+    return nil
+}
+
+// writePrivMsg writes message line by line as PRIVMSGs from nick to target
+func (h *IRCMessageTransport) writePrivMsg(from, target, prefix, message string) error {
+    for _, line := range ircLines(message) {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+
+        if err := h.writeLine(":%s PRIVMSG %s :%s%s", ircUserPrefix(from), target, prefix, line); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (h *IRCMessageTransport) writeRecipientMessage(msg *RecipientMessage) error {
+    if msg.To == ricaEvents.FROM_SERVER {
+        return nil
+    }
+
+    channel := ircGroupToChannel(msg.To)
+    switch msg.EventName {
+    case ricaEvents.JOIN_GROUP_REPLY:
+        if err := h.writeLine(":%s JOIN %s", ircUserPrefix(msg.From), channel); err != nil {
+            return err
+        }
+
+        // Clients expect topic and member list right after their own join
+        if msg.From == h.currentNick() {
+            h.Lock()
+            h.channels[msg.To] = true
+            h.Unlock()
+
+            h.writeReply("331", "%s :No topic is set", channel)
+            h.queueCommand(&StringMessage{
+                BaseMessage: BaseMessage{EventName: ricaEvents.LIST_MEMBERS_COMMAND},
+                Message:     msg.To,
+            })
+        }
+    case ricaEvents.LEAVE_GROUP_REPLY:
+        if msg.From == h.currentNick() {
+            h.Lock()
+            delete(h.channels, msg.To)
+            h.Unlock()
+        }
+
+        return h.writeLine(":%s PART %s", ircUserPrefix(msg.From), channel)
+    }
+
+    return nil
+}
+
+func (h *IRCMessageTransport) writeRecipientContentMessage(msg *RecipientContentMessage) error {
+    switch msg.EventName {
+    case ricaEvents.LIST_MEMBERS_REPLY:
+        members, ok := msg.Message.([]string)
+        if !ok {
+            return nil
+        }
+
+        channel := ircGroupToChannel(msg.To)
+        if err := h.writeReply("353", "= %s :%s", channel, strings.Join(members, " ")); err != nil {
+            return err
+        }
+        return h.writeReply("366", "%s :End of NAMES list", channel)
+    case ricaEvents.MEMBER_NICK_SET_REPLY:
+        nickMsg, ok := msg.Message.(*NickMessage)
+        if !ok || msg.To == ricaEvents.FROM_SERVER {
+            return nil
+        }
+
+        // The same nick change is published on every shared group
+        h.Lock()
+        duplicate := nickMsg.Id == h.lastNickMsgId
+        h.lastNickMsgId = nickMsg.Id
+        h.Unlock()
+
+        if duplicate || nickMsg.NewNick == h.currentNick() {
+            return nil
+        }
+        return h.writeLine(":%s NICK :%s", ircUserPrefix(nickMsg.OldNick), nickMsg.NewNick)
+    }
+
+    return nil
+}
+
+func (h *IRCMessageTransport) BeginBatch(id uint64, msg IEventMessage) {
+}
+
+func (h *IRCMessageTransport) FlushBatch(id uint64) {
+}
+
+func (h *IRCMessageTransport) Close() {
+    h.closeOnce.Do(func() {
+        close(h.done)
+    })
+    h.connection.Close()
+}
+
+func (h *IRCMessageTransport) currentNick() string {
+    h.Lock()
+    defer h.Unlock()
+
+    return h.nick
+}
+
+func (h *IRCMessageTransport) writeReply(numeric string, format string, args ...interface{}) error {
+    nick := h.currentNick()
+    if nick == "" {
+        nick = "*"
+    }
+
+    return h.writeLine(":%s %s %s %s", cIRCServerName, numeric, nick, fmt.Sprintf(format, args...))
+}
+
+// writeLine drops lines that would break out of a single IRC line, like a
+// group name carrying CR or LF, rather than let them inject commands
+func (h *IRCMessageTransport) writeLine(format string, args ...interface{}) error {
+    line := fmt.Sprintf(format, args...)
+    if strings.ContainsAny(line, "\r\n\x00") {
+        log.Println("Dropping unsafe IRC line", strconv.Quote(line))
+        return nil
+    }
+
+    h.writeLock.Lock()
+    defer h.writeLock.Unlock()
+
+    h.connection.SetWriteDeadline(time.Now().Add(cIRCWriteTimeout))
+    _, err := io.WriteString(h.connection, line+"\r\n")
+    return err
+}
+
+// Any of CR LF, CR and LF ends a line of text, NULs can't be sent at all
+var pIRCLineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", "")
+
+// ircLines splits text into lines that can each go out as one IRC message
+func ircLines(text string) []string {
+    return strings.Split(pIRCLineBreaks.Replace(text), "\n")
+}
+
+// ircPong turns "PONG [server] :<token>" into a pong for the handler, the
+// token is the time our PING carried
+func ircPong(params []string) IEventMessage {
+    pong := &PingMessage{
+        BaseMessage: BaseMessage{EventName: ricaEvents.PING_REPLY},
+    }
+
+    if len(params) > 0 {
+        pong.Type, _ = strconv.ParseInt(params[len(params)-1], 10, 64)
+    }
+
+    return pong
+}
+
+// parseIRCLine splits "[:prefix] COMMAND param param :trailing param"
+func parseIRCLine(line string) (string, []string) {
+    line = strings.TrimSpace(line)
+    if strings.HasPrefix(line, ":") {
+        if i := strings.Index(line, " "); i >= 0 {
+            line = strings.TrimSpace(line[i+1:])
+        } else {
+            return "", nil
+        }
+    }
+
+    trailing := ""
+    hasTrailing := false
+    if i := strings.Index(line, " :"); i >= 0 {
+        trailing = line[i+2:]
+        hasTrailing = true
+        line = line[:i]
+    }
+
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+        return "", nil
+    }
+
+    params := fields[1:]
+    if hasTrailing {
+        params = append(params, trailing)
+    }
+
+    return strings.ToUpper(fields[0]), params
+}
+
+func isIRCChannel(name string) bool {
+    return len(name) > 1 && (name[0] == '#' || name[0] == '&')
+}
+
+func ircChannelToGroup(channel string) string {
+    return channel[1:]
+}
+
+func ircGroupToChannel(group string) string {
+    return "#" + group
+}
+
+func ircUserPrefix(nick string) string {
+    return nick + "!" + nick + "@" + cIRCServerName
+}
@@ -0,0 +1,238 @@
+package rica
+
+import (
+    "bufio"
+    "io"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "sibte.so/rica/consts"
+)
+
+// ircClient is the client end of a registered IRC transport
+type ircClient struct {
+    t     *testing.T
+    conn  net.Conn
+    lines *bufio.Reader
+    trans *IRCMessageTransport
+}
+
+func newIRCClient(t *testing.T, nick string) *ircClient {
+    server, conn := net.Pipe()
+    c := &ircClient{
+        t:     t,
+        conn:  conn,
+        lines: bufio.NewReader(conn),
+        trans: NewIRCMessageTransport(server),
+    }
+
+    // The welcome burst blocks on the pipe until the client reads it
+    go func() {
+        c.trans.WriteMessage(1, &NickMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.SET_NICK_REPLY},
+            NewNick:     nick,
+        })
+        c.trans.translateLine("NICK " + nick)
+        c.trans.translateLine("USER " + nick + " 0 * :" + nick)
+    }()
+
+    c.expect(" 376 ")
+    return c
+}
+
+func (c *ircClient) write(line string) {
+    c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+    c.conn.Write([]byte(line + "\r\n"))
+}
+
+// expect reads lines until one contains text
+func (c *ircClient) expect(text string) string {
+    c.t.Helper()
+
+    c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    for {
+        line, err := c.lines.ReadString('\n')
+        if err != nil {
+            c.t.Fatalf("Expected a line with %q: %v", text, err)
+        }
+
+        if strings.Contains(line, text) {
+            return line
+        }
+    }
+}
+
+func TestIRCPrivMsgToNickIsDirectMessage(t *testing.T) {
+    c := newIRCClient(t, "alice")
+    defer c.trans.Close()
+
+    go c.write("PRIVMSG bob :hi there")
+    msg, err := c.trans.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    chat, ok := msg.(*ChatMessage)
+    if !ok || chat.To != "@bob" || chat.Message != "hi there" {
+        t.Fatalf("Expected a message to @bob, got %#v", msg)
+    }
+}
+
+func TestIRCDirectMessagesAndMentionsArePrivMsgs(t *testing.T) {
+    c := newIRCClient(t, "alice")
+    defer c.trans.Close()
+
+    dm := &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.DIRECT_MSG_REPLY},
+            From:        "bob",
+            To:          "alice",
+        },
+        Message: "psst",
+    }
+    go c.trans.WriteMessage(2, dm)
+    if line := c.expect("PRIVMSG"); !strings.HasPrefix(line, ":bob!") || !strings.Contains(line, "PRIVMSG alice :psst") {
+        t.Fatalf("Unexpected direct message %q", line)
+    }
+
+    mention := &ChatMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.MENTION_REPLY},
+            From:        "bob",
+            To:          "lounge",
+        },
+        Message: "@alice look",
+    }
+    go c.trans.WriteMessage(3, mention)
+    if line := c.expect("PRIVMSG"); !strings.Contains(line, "PRIVMSG alice :#lounge: @alice look") {
+        t.Fatalf("Unexpected mention %q", line)
+    }
+}
+
+// readUntil returns the lines before the first one containing text
+func (c *ircClient) readUntil(text string) []string {
+    c.t.Helper()
+
+    lines := []string{}
+    c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    for {
+        line, err := c.lines.ReadString('\n')
+        if err != nil {
+            c.t.Fatalf("Expected a line with %q: %v", text, err)
+        }
+
+        if strings.Contains(line, text) {
+            return lines
+        }
+        lines = append(lines, line)
+    }
+}
+
+func TestIRCNamesListsMembers(t *testing.T) {
+    c := newIRCClient(t, "alice")
+    defer c.trans.Close()
+
+    go c.write("NAMES #lounge")
+    msg, err := c.trans.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cmd, ok := msg.(*StringMessage)
+    if !ok || cmd.EventName != ricaEvents.LIST_MEMBERS_COMMAND || cmd.Message != "lounge" {
+        t.Fatalf("Expected a member list command for lounge, got %#v", msg)
+    }
+
+    // The handler answers with the members and their presence
+    go c.trans.WriteMessage(2, &GroupListMessage{
+        RecipientContentMessage: RecipientContentMessage{
+            RecipientMessage: RecipientMessage{
+                BaseMessage: BaseMessage{EventName: ricaEvents.LIST_MEMBERS_REPLY},
+                From:        ricaEvents.FROM_SERVER,
+                To:          "lounge",
+            },
+            Message: []string{"alice", "bob"},
+        },
+        Presence: map[string]Presence{},
+    })
+
+    if line := c.expect(" 353 "); !strings.Contains(line, "= #lounge :alice bob") {
+        t.Fatalf("Unexpected NAMES reply %q", line)
+    }
+    c.expect(" 366 ")
+}
+
+func TestIRCLineBreaksCantInjectCommands(t *testing.T) {
+    c := newIRCClient(t, "alice")
+    defer c.trans.Close()
+
+    evil := ":admin!a@rica PRIVMSG alice :evil"
+    go func() {
+        for _, text := range []string{"hi\r" + evil, "hi\r\n" + evil, "hi\n" + evil, "hi\x00\r" + evil} {
+            c.trans.WriteMessage(2, &ChatMessage{
+                RecipientMessage: RecipientMessage{
+                    BaseMessage: BaseMessage{EventName: ricaEvents.GROUP_MSG_REPLY},
+                    From:        "bob",
+                    To:          "lounge",
+                },
+                Message: text,
+            })
+        }
+
+        group := "x\r\n" + evil
+        for _, event := range []string{ricaEvents.JOIN_GROUP_REPLY, ricaEvents.LEAVE_GROUP_REPLY} {
+            c.trans.WriteMessage(3, &RecipientMessage{
+                BaseMessage: BaseMessage{EventName: event},
+                From:        "bob",
+                To:          group,
+            })
+        }
+        c.trans.WriteMessage(4, &RecipientContentMessage{
+            RecipientMessage: RecipientMessage{
+                BaseMessage: BaseMessage{EventName: ricaEvents.LIST_MEMBERS_REPLY},
+                To:          group,
+            },
+            Message: []string{"bob"},
+        })
+        c.trans.WriteMessage(5, &PingMessage{Type: 42})
+    }()
+
+    lines := c.readUntil("PING :42")
+    if len(lines) != 8 {
+        t.Errorf("Expected the 4 messages as 8 PRIVMSGs, got %q", lines)
+    }
+
+    for _, line := range lines {
+        if !strings.HasPrefix(line, ":bob!bob@rica PRIVMSG #lounge :") || !strings.HasSuffix(line, "\r\n") || strings.ContainsAny(strings.TrimSuffix(line, "\r\n"), "\r\n\x00") {
+            t.Errorf("Injected line %q", line)
+        }
+    }
+}
+
+func TestIRCCloseStopsLineReader(t *testing.T) {
+    c := newIRCClient(t, "alice")
+
+    // Nobody reads these lines, so the reader ends up blocked on a full queue
+    for i := 0; i <= cap(c.trans.lines); i++ {
+        c.write("PING :x")
+    }
+
+    c.trans.Close()
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        c.trans.Lock()
+        err := c.trans.readErr
+        c.trans.Unlock()
+
+        if err == io.ErrClosedPipe {
+            return
+        }
+
+        if time.Now().After(deadline) {
+            t.Fatal("Line reader still running after Close")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
@@ -0,0 +1,131 @@
+package rica
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "sibte.so/rica/consts"
+)
+
+// Ready reports if the service accepts new connections
+func (c *ChatService) Ready() bool {
+    c.Lock()
+    defer c.Unlock()
+
+    return !c.draining
+}
+
+// Shutdown stops accepting connections, tells every connected client the
+// server is going away, waits for handlers to drain and closes the stores.
+// Stores are closed even if ctx expires before every handler stopped
+func (c *ChatService) Shutdown(ctx context.Context) error {
+    return c.shutdown(ctx, nil)
+}
+
+// ShutdownForRestart is Shutdown for a hot restart, clients are asked to
+// reconnect and get a token to resume their nick and groups on the new
+// process. The stores are closed as soon as the tokens are saved and
+// storesClosed is called, so the new process can take over before the
+// clients of this one disconnected. Messages handled in between aren't saved
+func (c *ChatService) ShutdownForRestart(ctx context.Context, storesClosed func()) error {
+    return c.shutdown(ctx, storesClosed)
+}
+
+func (c *ChatService) shutdown(ctx context.Context, storesClosed func()) error {
+    restarting := storesClosed != nil
+    c.Lock()
+    c.draining = true
+    listeners := c.listeners
+    c.listeners = nil
+    handlers := make([]*ChatHandler, 0, len(c.handlers))
+    for h := range c.handlers {
+        handlers = append(handlers, h)
+    }
+    c.Unlock()
+
+    for _, l := range listeners {
+        l.Close()
+    }
+
+    drainTimeout := 5 * time.Second
+    if deadline, ok := ctx.Deadline(); ok {
+        drainTimeout = deadline.Sub(time.Now()) / 2
+    }
+
+    log.Println("Disconnecting", len(handlers), "clients")
+    for _, h := range handlers {
+        go h.GoAway(c.goingAwayNotice(h, restarting), drainTimeout)
+    }
+
+    // Every resume token is saved, the new process can have the stores
+    if restarting {
+        c.closeStores()
+        storesClosed()
+    }
+
+    done := make(chan struct{})
+    go func() {
+        c.handlersDone.Wait()
+        close(done)
+    }()
+
+    var err error
+    select {
+    case <-done:
+    case <-ctx.Done():
+        err = ctx.Err()
+        log.Println("Shutdown timed out waiting for clients to disconnect")
+    }
+
+    if !restarting {
+        c.closeStores()
+    }
+    return err
+}
+
+func (c *ChatService) goingAwayNotice(h *ChatHandler, restarting bool) *GoingAwayMessage {
+    notice := &GoingAwayMessage{
+        BaseMessage: messageOf(ricaEvents.SERVER_GOING_AWAY),
+        Message:     "Server is shutting down",
+    }
+
+    if !restarting {
+        return notice
+    }
+
+    notice.Message = "Server is restarting"
+    notice.Reconnect = true
+    token, err := newTransportSessionId()
+    if err == nil {
+        err = c.sessionStore.Save(token, h.resumableSession())
+    }
+
+    if err != nil {
+        log.Println("Unable to save resumable session", err)
+        return notice
+    }
+
+    notice.ResumeToken = token
+    return notice
+}
+
+func (c *ChatService) closeStores() {
+    closers := []interface {
+        Close() error
+    }{c.chatStore, c.mentionStore, c.sessionStore, c.presenceStore}
+
+    if c.pushNotifier != nil {
+        closers = append(closers, c.prefsStore, c.pushNotifier.store)
+    }
+
+    if c.cluster != nil {
+        closers = append(closers, c.cluster.bus)
+    }
+
+    for _, s := range closers {
+        if err := s.Close(); err != nil {
+            log.Println("Unable to close store", err)
+        }
+    }
+}
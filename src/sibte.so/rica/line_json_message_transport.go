@@ -0,0 +1,88 @@
+package rica
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "net"
+    "sync"
+    "time"
+)
+
+const (
+    cLineJSONMaxLineSize  = 64 << 10
+    cLineJSONWriteTimeout = 10 * time.Second
+
+    // Clients answer pings, a connection silent for this long is dead
+    cLineJSONReadTimeout = 60 * time.Second
+)
+
+// LineJSONMessageTransport exchanges the websocket JSON messages as newline
+// delimited JSON over a plain (or TLS) stream connection
+type LineJSONMessageTransport struct {
+    connection          net.Conn
+    scanner             *bufio.Scanner
+    connectionReadLock  *sync.Mutex
+    connectionWriteLock *sync.Mutex
+}
+
+func NewLineJSONMessageTransport(conn net.Conn) *LineJSONMessageTransport {
+    scanner := bufio.NewScanner(conn)
+    scanner.Buffer(make([]byte, 4096), cLineJSONMaxLineSize)
+
+    return &LineJSONMessageTransport{
+        connection:          conn,
+        scanner:             scanner,
+        connectionReadLock:  &sync.Mutex{},
+        connectionWriteLock: &sync.Mutex{},
+    }
+}
+
+func (h *LineJSONMessageTransport) ReadMessage() (IEventMessage, error) {
+    h.connectionReadLock.Lock()
+    defer h.connectionReadLock.Unlock()
+
+    for {
+        h.connection.SetReadDeadline(time.Now().Add(cLineJSONReadTimeout))
+        if !h.scanner.Scan() {
+            break
+        }
+
+        line := h.scanner.Bytes()
+        if len(line) == 0 {
+            continue
+        }
+
+        return transportDecodeMessage(line)
+    }
+
+    if err := h.scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    return nil, errors.New("Connection closed by peer")
+}
+
+func (h *LineJSONMessageTransport) WriteMessage(id uint64, msg IEventMessage) error {
+    body, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+
+    h.connectionWriteLock.Lock()
+    defer h.connectionWriteLock.Unlock()
+
+    h.connection.SetWriteDeadline(time.Now().Add(cLineJSONWriteTimeout))
+    _, err = h.connection.Write(append(body, '\n'))
+    return err
+}
+
+func (h *LineJSONMessageTransport) FlushBatch(id uint64) {
+}
+
+func (h *LineJSONMessageTransport) BeginBatch(id uint64, msg IEventMessage) {
+}
+
+func (h *LineJSONMessageTransport) Close() {
+    h.connection.Close()
+}
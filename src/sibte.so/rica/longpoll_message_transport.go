@@ -0,0 +1,156 @@
+package rica
+
+import (
+    "io"
+    "log"
+    "sync"
+    "time"
+)
+
+const (
+    cLongPollTimeout     = 25 * time.Second
+    cLongPollIdleTimeout = 60 * time.Second
+    cLongPollMaxPending  = 512
+)
+
+// LongPollMessageTransport buffers outgoing messages for a session until the
+// client collects them with a poll request, commands are posted separately
+type LongPollMessageTransport struct {
+    sync.Mutex
+    sessionId string
+    incoming  chan []byte
+    pending   []IEventMessage
+    notify    chan struct{}
+    lastPoll  time.Time
+    closed    chan struct{}
+    closeOnce *sync.Once
+}
+
+func NewLongPollMessageTransport(sessionId string) *LongPollMessageTransport {
+    return &LongPollMessageTransport{
+        sessionId: sessionId,
+        incoming:  make(chan []byte, 32),
+        pending:   make([]IEventMessage, 0),
+        notify:    make(chan struct{}, 1),
+        lastPoll:  time.Now(),
+        closed:    make(chan struct{}),
+        closeOnce: &sync.Once{},
+    }
+}
+
+func (h *LongPollMessageTransport) ReadMessage() (IEventMessage, error) {
+    select {
+    case msg := <-h.incoming:
+        return transportDecodeMessage(msg)
+    case <-h.closed:
+        return nil, io.EOF
+    }
+}
+
+func (h *LongPollMessageTransport) WriteMessage(id uint64, msg IEventMessage) error {
+    select {
+    case <-h.closed:
+        return io.ErrClosedPipe
+    default:
+    }
+
+    h.Lock()
+    if len(h.pending) >= cLongPollMaxPending {
+        log.Println("Long poll buffer full dropping oldest message for", h.sessionId)
+        h.pending = h.pending[1:]
+    }
+    h.pending = append(h.pending, msg)
+    h.Unlock()
+
+    select {
+    case h.notify <- struct{}{}:
+    default:
+    }
+
+    return nil
+}
+
+func (h *LongPollMessageTransport) BeginBatch(id uint64, msg IEventMessage) {
+}
+
+func (h *LongPollMessageTransport) FlushBatch(id uint64) {
+}
+
+// PostMessage hands a raw command received over HTTP to the reader side
+func (h *LongPollMessageTransport) PostMessage(msg []byte) error {
+    select {
+    case h.incoming <- msg:
+        return nil
+    case <-h.closed:
+        return io.ErrClosedPipe
+    }
+}
+
+func (h *LongPollMessageTransport) Close() {
+    h.closeOnce.Do(func() {
+        close(h.closed)
+    })
+}
+
+// Poll waits up to timeout for outgoing messages and returns everything buffered
+func (h *LongPollMessageTransport) Poll(timeout time.Duration, done <-chan struct{}) ([]IEventMessage, error) {
+    h.touch()
+    defer h.touch()
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    for {
+        if msgs := h.drain(); len(msgs) > 0 {
+            return msgs, nil
+        }
+
+        select {
+        case <-h.notify:
+        case <-timer.C:
+            return h.drain(), nil
+        case <-done:
+            return nil, io.EOF
+        case <-h.closed:
+            return nil, io.ErrClosedPipe
+        }
+    }
+}
+
+// ExpireWhenIdle closes the transport once the client stops polling for idle
+func (h *LongPollMessageTransport) ExpireWhenIdle(idle time.Duration) {
+    ticker := time.NewTicker(idle / 4)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            h.Lock()
+            lastPoll := h.lastPoll
+            h.Unlock()
+
+            if time.Since(lastPoll) > idle {
+                log.Println("Long poll session expired", h.sessionId)
+                h.Close()
+                return
+            }
+        case <-h.closed:
+            return
+        }
+    }
+}
+
+func (h *LongPollMessageTransport) touch() {
+    h.Lock()
+    h.lastPoll = time.Now()
+    h.Unlock()
+}
+
+func (h *LongPollMessageTransport) drain() []IEventMessage {
+    h.Lock()
+    defer h.Unlock()
+
+    ret := h.pending
+    h.pending = make([]IEventMessage, 0)
+    return ret
+}
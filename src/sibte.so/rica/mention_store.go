@@ -0,0 +1,74 @@
+package rica
+
+import (
+    "regexp"
+
+    "github.com/syndtr/goleveldb/leveldb"
+    "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var mentionRegex = regexp.MustCompile(`@([_A-Za-z0-9]+)`)
+
+// parseMentions returns the distinct nicks mentioned in message
+func parseMentions(message string) []string {
+    seen := make(map[string]bool)
+    ret := []string{}
+    for _, m := range mentionRegex.FindAllStringSubmatch(message, -1) {
+        if !seen[m[1]] {
+            seen[m[1]] = true
+            ret = append(ret, m[1])
+        }
+    }
+
+    return ret
+}
+
+// MentionStore indexes messages by the nicks they mention, the messages
+// themselves stay in ChatLogStore
+type MentionStore struct {
+    store *leveldb.DB
+}
+
+func NewMentionStore(path string) (*MentionStore, error) {
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    return &MentionStore{
+        store: db,
+    }, nil
+}
+
+func mentionKeyPrefix(nick string) []byte {
+    return append([]byte(nick), 0)
+}
+
+// Save records that message id mentions nick
+func (s *MentionStore) Save(nick string, id uint64) error {
+    defer StartStopWatch(pStoreDuration.With("mentions", "save")).ObserveDuration()
+
+    // <nick>\0<id> -> byte[0]
+    return s.store.Put(append(mentionKeyPrefix(nick), idToBytes(id)...), make([]byte, 0), nil)
+}
+
+// Recent returns up to limit message ids mentioning nick, newest first
+func (s *MentionStore) Recent(nick string, limit uint) ([]uint64, error) {
+    defer StartStopWatch(pStoreDuration.With("mentions", "recent")).ObserveDuration()
+
+    ret := []uint64{}
+
+    iter := s.store.NewIterator(util.BytesPrefix(mentionKeyPrefix(nick)), nil)
+    defer iter.Release()
+
+    for ok := iter.Last(); ok && uint(len(ret)) < limit; ok = iter.Prev() {
+        k := iter.Key()
+        ret = append(ret, bytesToId(k[len(k)-8:]))
+    }
+
+    return ret, iter.Error()
+}
+
+func (s *MentionStore) Close() error {
+    return s.store.Close()
+}
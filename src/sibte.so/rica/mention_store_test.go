@@ -0,0 +1,46 @@
+package rica
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestResolveMentionsOnlyKnownNicks(t *testing.T) {
+    dir, err := ioutil.TempDir("", "rica-mentions")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    presence, err := NewPresenceStore(dir + "/presence.leveldb")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer presence.Close()
+
+    nicks := NewNickRegistry()
+    nicks.Register("id-bob", "bob")
+    presence.SaveLastSeen("carol", time.Now())
+
+    h := &ChatHandler{nick: "alice", nickRegistry: nicks, presenceStore: presence}
+    mentioned, ids := h.resolveMentions("@alice @bob @carol @nobody")
+    if strings.Join(mentioned, ",") != "bob,carol" || strings.Join(ids, ",") != "id-bob" {
+        t.Fatalf("Resolved %v %v", mentioned, ids)
+    }
+
+    // Only the first cMaxMentionsPerMessage known nicks count
+    message := ""
+    for i := 0; i <= cMaxMentionsPerMessage; i++ {
+        nick := fmt.Sprint("user", i)
+        nicks.Register("id-"+nick, nick)
+        message += " @" + nick
+    }
+
+    if mentioned, _ := h.resolveMentions(message); len(mentioned) != cMaxMentionsPerMessage {
+        t.Fatalf("Resolved %d mentions, expected %d", len(mentioned), cMaxMentionsPerMessage)
+    }
+}
@@ -0,0 +1,146 @@
+package rica
+
+import (
+    "time"
+
+    "sibte.so/rasconfig"
+)
+
+type IEventMessage interface {
+    Identity() uint64
+    Event() string
+    Stamp()
+}
+
+type BaseMessage struct {
+    EventName    string `json:"@"`
+    Id           uint64 `json:"!id,omitempty"`
+    UTCTimestamp int64  `json:"utc_timestamp"`
+}
+
+func (b *BaseMessage) Identity() uint64 {
+    return b.Id
+}
+
+func (b *BaseMessage) Event() string {
+    return b.EventName
+}
+
+func (b *BaseMessage) Stamp() {
+    b.UTCTimestamp = time.Now().Unix()
+}
+
+// PingMessage is sent periodically with the server time in unix milliseconds
+// which clients echo back in a pong, Latency is the last measured round trip
+type PingMessage struct {
+    BaseMessage
+    Type    int64 `json:"t"`
+    Latency int64 `json:"latency_ms,omitempty"`
+}
+
+// HandshakeMessage is a client's hello stating the protocol version and
+// features it supports, Nick and Rooms are applied like set-nick and
+// join-group commands
+type HandshakeMessage struct {
+    BaseMessage
+    Nick     string   `json:"nick"`
+    Rooms    []string `json:"rooms"`
+    Version  int      `json:"version"`
+    Features []string `json:"features"`
+}
+
+// ProtocolLimits are the limits a client has to stay within, RateLimits
+// holds the per connection limit of each rate limited action
+type ProtocolLimits struct {
+    MaxMessageLength    int                            `json:"max_message_length"`
+    MaxNickLength       int                            `json:"max_nick_length"`
+    MaxStatusTextLength int                            `json:"max_status_text_length"`
+    OutboundQueueSize   int                            `json:"outbound_queue_size"`
+    RateLimits          map[string]rasconfig.RateLimit `json:"rate_limits,omitempty"`
+}
+
+// ServerHelloMessage answers a hello with the protocol version the server
+// speaks, the oldest one it accepts, its enabled features, limits and time in
+// unix milliseconds
+type ServerHelloMessage struct {
+    BaseMessage
+    Version    int            `json:"version"`
+    MinVersion int            `json:"min_version"`
+    Features   []string       `json:"features"`
+    Limits     ProtocolLimits `json:"limits"`
+    ServerTime int64          `json:"server_time"`
+    APIToken   string         `json:"api_token,omitempty"`
+}
+
+type RecipientMessage struct {
+    BaseMessage
+    To   string `json:"to"`
+    From string `json:"from"`
+}
+
+type ChatMessage struct {
+    RecipientMessage
+    Message  string   `json:"msg"`
+    Mentions []string `json:"mentions,omitempty"`
+}
+
+type RecipientContentMessage struct {
+    RecipientMessage
+    Message interface{} `json:"pack_msg"`
+}
+
+type NickMessage struct {
+    BaseMessage
+    OldNick string `json:"oldNick"`
+    NewNick string `json:"newNick"`
+}
+
+type StringMessage struct {
+    BaseMessage
+    Message string `json:"msg"`
+}
+
+// GoingAwayMessage is sent before the server closes a connection, clients
+// that reconnect can send ResumeToken to get their nick and groups back
+type GoingAwayMessage struct {
+    BaseMessage
+    Message     string `json:"msg"`
+    Reconnect   bool   `json:"reconnect"`
+    ResumeToken string `json:"resume_token,omitempty"`
+}
+
+// StatusMessage sets the sender's presence status and custom status text
+type StatusMessage struct {
+    BaseMessage
+    Status string `json:"status"`
+    Text   string `json:"text"`
+}
+
+// Presence is a user's status as others see it, LastSeen is the unix time
+// of the user's last activity
+type Presence struct {
+    Status   string `json:"status"`
+    Text     string `json:"text,omitempty"`
+    Idle     bool   `json:"idle,omitempty"`
+    LastSeen int64  `json:"last_seen,omitempty"`
+}
+
+// PresenceMessage announces a presence change of user From to group To
+type PresenceMessage struct {
+    RecipientMessage
+    Presence
+    User string `json:"user"`
+}
+
+// GroupListMessage lists the nicks of a group along with their presence
+type GroupListMessage struct {
+    RecipientContentMessage
+    Presence map[string]Presence `json:"presence"`
+}
+
+type ErrorMessage struct {
+    BaseMessage
+    Type  string      `json:"error_type"`
+    Error string      `json:"error"`
+    Body  interface{} `json:"body"`
+}
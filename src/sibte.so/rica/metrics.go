@@ -0,0 +1,58 @@
+package rica
+
+import (
+    "sibte.so/rasmetrics"
+)
+
+var (
+    pConnectionsGauge = rasmetrics.NewGaugeVec(
+        "rica_connections", "Connected chat handlers by transport", "transport")
+    pMessagesPublished = rasmetrics.NewCounterVec(
+        "rica_messages_published_total", "Messages published by kind, group or direct", "kind")
+    pPublishDuration = rasmetrics.NewHistogramVec(
+        "rica_publish_fanout_seconds", "Time to store and fan out a message to every member",
+        rasmetrics.DefaultBuckets).With()
+    pOperationDuration = rasmetrics.NewHistogramVec(
+        "rica_operation_duration_seconds", "Chat handler operation latencies",
+        rasmetrics.DefaultBuckets, "operation")
+    pOutboundDropped = rasmetrics.NewCounterVec(
+        "rica_outbound_dropped_total", "Messages dropped from full outbound queues").With()
+    pOutboundDisconnects = rasmetrics.NewCounterVec(
+        "rica_outbound_disconnects_total", "Slow clients disconnected on a full outbound queue").With()
+    pStoreDuration = rasmetrics.NewHistogramVec(
+        "rica_leveldb_duration_seconds", "leveldb operation latencies",
+        rasmetrics.DefaultBuckets, "store", "operation")
+    pPingRoundTrip = rasmetrics.NewHistogramVec(
+        "rica_ping_round_trip_seconds", "Time between a ping and the client's pong",
+        rasmetrics.DefaultBuckets).With()
+    pUnresponsiveDisconnects = rasmetrics.NewCounterVec(
+        "rica_unresponsive_disconnects_total", "Connections closed after their read deadline passed", "transport")
+    pClockRegressions = rasmetrics.NewCounterVec(
+        "rica_snowflake_clock_regressions_total", "Backwards clock jumps seen while generating ids", "action")
+    pSequenceExhausted = rasmetrics.NewCounterVec(
+        "rica_snowflake_sequence_exhausted_total", "Milliseconds that ran out of id sequence numbers").With()
+    pWorkerIdCollisions = rasmetrics.NewCounterVec(
+        "rica_snowflake_worker_id_collisions_total", "Worker id claims found held by another node").With()
+    pClientHellos = rasmetrics.NewCounterVec(
+        "rica_client_hellos_total", "Hellos received by client protocol version", "version")
+    pInvalidCommands = rasmetrics.NewCounterVec(
+        "rica_invalid_commands_total", "Client messages rejected as unknown or malformed", "error_type")
+)
+
+// transportName labels connection metrics
+func transportName(t IMessageTransport) string {
+    switch t.(type) {
+    case *WebsocketMessageTransport:
+        return "websocket"
+    case *SSEMessageTransport:
+        return "sse"
+    case *LongPollMessageTransport:
+        return "longpoll"
+    case *IRCMessageTransport:
+        return "irc"
+    case *LineJSONMessageTransport:
+        return "json"
+    }
+
+    return "unknown"
+}
@@ -0,0 +1,87 @@
+package rica
+
+import (
+    "errors"
+    "strconv"
+    "testing"
+)
+
+func BenchmarkNickRegistryRegisterUnregister(b *testing.B) {
+    r := NewNickRegistry()
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        id := strconv.Itoa(i)
+        r.Register(id, "nick"+id)
+        r.Unregister(id)
+    }
+}
+
+func BenchmarkNickRegistryNickOf(b *testing.B) {
+    r := NewNickRegistry()
+    for i := 0; i < 1000; i++ {
+        r.Register(strconv.Itoa(i), "nick"+strconv.Itoa(i))
+    }
+
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        i := 0
+        for pb.Next() {
+            r.NickOf(strconv.Itoa(i % 1000))
+            i++
+        }
+    })
+}
+
+// Every id asks for the same nick, so all but the first get a suffix
+func BenchmarkNickRegistrySetBestPossibleNickTaken(b *testing.B) {
+    r := NewNickRegistry()
+    r.Register("owner", "alice")
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        id := strconv.Itoa(i)
+        r.SetBestPossibleNick(id, "alice")
+        r.Unregister(id)
+    }
+}
+
+// unreachableBus fails every nick claim as an unreachable Redis would
+type unreachableBus struct {
+    ClusterBus
+}
+
+func (unreachableBus) ClaimNick(nick, id string) (bool, error) {
+    return false, errors.New("connection refused")
+}
+
+// Nicks are still handed out on this node while the cluster is unreachable
+func TestNickRegistryRegistersLocallyWhenBusFails(t *testing.T) {
+    r := NewNickRegistry()
+    r.cluster = unreachableBus{}
+
+    if !r.Register("id1", "alice") {
+        t.Fatal("Register failed with the bus down")
+    }
+
+    if r.Register("id2", "alice") {
+        t.Fatal("Nick registered twice on one node")
+    }
+}
+
+func TestNickRegistryNickChangeReleasesOldClaim(t *testing.T) {
+    hub := NewInProcessHub()
+    r := NewNickRegistry()
+    r.cluster = hub.Bus()
+
+    r.Register("id1", "alice")
+    r.Register("id1", "alicia")
+
+    if _, ok := r.cluster.NickOwner("alice"); ok {
+        t.Fatal("Old nick still claimed")
+    }
+
+    if id, ok := r.cluster.NickOwner("alicia"); !ok || id != "id1" {
+        t.Fatalf("New nick owner %q %v", id, ok)
+    }
+}
@@ -0,0 +1,126 @@
+package rica
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/syndtr/goleveldb/leveldb"
+)
+
+// QuietHours is a daily window ("HH:MM" in the user's UTC offset) during
+// which no push notifications are delivered, the window may wrap midnight
+type QuietHours struct {
+    Start            string `json:"start"`
+    End              string `json:"end"`
+    UTCOffsetMinutes int    `json:"utc_offset_minutes"`
+}
+
+type NotificationPrefs struct {
+    MutedGroups []string    `json:"muted_groups"`
+    QuietHours  *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// Allows reports if a notification from group may be delivered at time at
+func (p *NotificationPrefs) Allows(group string, at time.Time) bool {
+    for _, g := range p.MutedGroups {
+        if g == group {
+            return false
+        }
+    }
+
+    if p.QuietHours == nil {
+        return true
+    }
+
+    start, errStart := parseClockMinutes(p.QuietHours.Start)
+    end, errEnd := parseClockMinutes(p.QuietHours.End)
+    if errStart != nil || errEnd != nil || start == end {
+        return true
+    }
+
+    local := at.UTC().Add(time.Duration(p.QuietHours.UTCOffsetMinutes) * time.Minute)
+    now := local.Hour()*60 + local.Minute()
+    if start < end {
+        return now < start || now >= end
+    }
+
+    return now < start && now >= end
+}
+
+// Validate checks the quiet hours are well formed
+func (p *NotificationPrefs) Validate() error {
+    if p.QuietHours == nil {
+        return nil
+    }
+
+    if _, err := parseClockMinutes(p.QuietHours.Start); err != nil {
+        return err
+    }
+
+    _, err := parseClockMinutes(p.QuietHours.End)
+    return err
+}
+
+func parseClockMinutes(clock string) (int, error) {
+    var h, m int
+    if _, err := fmt.Sscanf(clock, "%d:%d", &h, &m); err != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+        return 0, fmt.Errorf("Invalid time of day %q, expected HH:MM", clock)
+    }
+
+    return h*60 + m, nil
+}
+
+type NotificationPrefsStore struct {
+    store *leveldb.DB
+}
+
+func NewNotificationPrefsStore(path string) (*NotificationPrefsStore, error) {
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    return &NotificationPrefsStore{
+        store: db,
+    }, nil
+}
+
+// Get returns preferences for user, or defaults if user never saved any
+func (s *NotificationPrefsStore) Get(user string) (*NotificationPrefs, error) {
+    defer StartStopWatch(pStoreDuration.With("notification_prefs", "get")).ObserveDuration()
+
+    prefs := &NotificationPrefs{
+        MutedGroups: []string{},
+    }
+
+    b, err := s.store.Get([]byte("prefs:"+user), nil)
+    if err == leveldb.ErrNotFound {
+        return prefs, nil
+    }
+
+    if err != nil {
+        return nil, err
+    }
+
+    if err = json.Unmarshal(b, prefs); err != nil {
+        return nil, err
+    }
+
+    return prefs, nil
+}
+
+func (s *NotificationPrefsStore) Save(user string, prefs *NotificationPrefs) error {
+    defer StartStopWatch(pStoreDuration.With("notification_prefs", "save")).ObserveDuration()
+
+    b, err := json.Marshal(prefs)
+    if err != nil {
+        return err
+    }
+
+    return s.store.Put([]byte("prefs:"+user), b, nil)
+}
+
+func (s *NotificationPrefsStore) Close() error {
+    return s.store.Close()
+}
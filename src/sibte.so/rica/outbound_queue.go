@@ -0,0 +1,117 @@
+package rica
+
+import (
+    "log"
+    "sync"
+    "sync/atomic"
+)
+
+const cDefaultOutboundQueueSize = 256
+
+// Policies applied when a connection's outbound queue is full
+const (
+    SlowClientDropOldest = "drop-oldest"
+    SlowClientDisconnect = "disconnect"
+)
+
+// OutboundStats counts messages lost to slow clients across all connections
+type OutboundStats struct {
+    Dropped      uint64 `json:"dropped"`
+    Disconnected uint64 `json:"disconnected"`
+}
+
+var pOutboundStats = &OutboundStats{}
+
+// CurrentOutboundStats returns a snapshot of the slow client counters
+func CurrentOutboundStats() OutboundStats {
+    return OutboundStats{
+        Dropped:      atomic.LoadUint64(&pOutboundStats.Dropped),
+        Disconnected: atomic.LoadUint64(&pOutboundStats.Disconnected),
+    }
+}
+
+// userOutGoingInfo is the bounded outbound queue of a single connection,
+// enqueue never blocks so one slow reader can't stall a publish
+type userOutGoingInfo struct {
+    sync.Mutex
+    channel    chan interface{}
+    ip         string
+    policy     string
+    closed     bool
+    dropped    uint64
+    onOverflow func()
+}
+
+func newUserOutGoingInfo(ip string, size int, policy string) *userOutGoingInfo {
+    if size <= 0 {
+        size = cDefaultOutboundQueueSize
+    }
+
+    if policy != SlowClientDisconnect {
+        policy = SlowClientDropOldest
+    }
+
+    return &userOutGoingInfo{
+        channel: make(chan interface{}, size),
+        ip:      ip,
+        policy:  policy,
+    }
+}
+
+// enqueue returns false if msg (or an older message) had to be dropped
+func (u *userOutGoingInfo) enqueue(msg interface{}) bool {
+    u.Lock()
+    defer u.Unlock()
+
+    if u.closed {
+        return false
+    }
+
+    select {
+    case u.channel <- msg:
+        return true
+    default:
+    }
+
+    if u.policy == SlowClientDisconnect {
+        u.closed = true
+        close(u.channel)
+        atomic.AddUint64(&pOutboundStats.Disconnected, 1)
+        pOutboundDisconnects.Inc()
+        log.Println("Outbound queue full disconnecting slow client", u.ip)
+        if u.onOverflow != nil {
+            go u.onOverflow()
+        }
+        return false
+    }
+
+    // Make room by discarding the oldest queued message
+    select {
+    case <-u.channel:
+    default:
+    }
+
+    u.dropped++
+    atomic.AddUint64(&pOutboundStats.Dropped, 1)
+    pOutboundDropped.Inc()
+    if u.dropped&(u.dropped-1) == 0 {
+        log.Println("Outbound queue full dropped", u.dropped, "messages for", u.ip)
+    }
+
+    select {
+    case u.channel <- msg:
+    default:
+    }
+    return false
+}
+
+// close stops accepting messages, the writer drains what is queued
+func (u *userOutGoingInfo) close() {
+    u.Lock()
+    defer u.Unlock()
+
+    if !u.closed {
+        u.closed = true
+        close(u.channel)
+    }
+}
@@ -0,0 +1,229 @@
+package rica
+
+import (
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "sibte.so/rasconfig"
+    "sibte.so/rica/consts"
+)
+
+const (
+    // Users with no activity for this long are shown as away
+    cDefaultIdleTimeout    = 5 * time.Minute
+    cPresenceCheckInterval = 15 * time.Second
+    cMaxStatusTextLength   = 128
+)
+
+// validStatus reports whether status can be set with set-status
+func validStatus(status string) bool {
+    switch status {
+    case ricaEvents.STATUS_ONLINE, ricaEvents.STATUS_AWAY, ricaEvents.STATUS_BUSY, ricaEvents.STATUS_INVISIBLE:
+        return true
+    }
+
+    return false
+}
+
+// visible returns p the way other users see it, invisible users appear
+// offline and their activity is hidden
+func (p Presence) visible() Presence {
+    if p.Status == ricaEvents.STATUS_INVISIBLE {
+        return Presence{Status: ricaEvents.STATUS_OFFLINE}
+    }
+
+    if p.Idle && p.Status == ricaEvents.STATUS_ONLINE {
+        p.Status = ricaEvents.STATUS_AWAY
+    }
+
+    return p
+}
+
+// PresenceRegistry tracks the presence of connected users by id, including
+// users of other cluster nodes
+type PresenceRegistry struct {
+    sync.RWMutex
+    users map[string]Presence
+}
+
+func NewPresenceRegistry() *PresenceRegistry {
+    return &PresenceRegistry{
+        users: make(map[string]Presence),
+    }
+}
+
+func (r *PresenceRegistry) Set(id string, p Presence) {
+    r.Lock()
+    defer r.Unlock()
+
+    r.users[id] = p
+}
+
+func (r *PresenceRegistry) Remove(id string) {
+    r.Lock()
+    defer r.Unlock()
+
+    delete(r.users, id)
+}
+
+// Get returns the presence of id as other users see it, connected users
+// without a known presence are online
+func (r *PresenceRegistry) Get(id string) Presence {
+    r.RLock()
+    defer r.RUnlock()
+
+    p, ok := r.users[id]
+    if !ok {
+        return Presence{Status: ricaEvents.STATUS_ONLINE}
+    }
+
+    return p.visible()
+}
+
+// onPresenceChange applies a presence announced by another node
+func (r *PresenceRegistry) onPresenceChange(msg *PresenceMessage) {
+    if msg.Status == ricaEvents.STATUS_OFFLINE {
+        r.Remove(msg.User)
+        return
+    }
+
+    r.Set(msg.User, msg.Presence)
+}
+
+// markActive records user activity, ending an automatic away
+func (h *ChatHandler) markActive(now time.Time) {
+    h.status.LastSeen = now.Unix()
+    h.status.Idle = false
+    h.updatePresence()
+}
+
+// checkIdle marks the user idle once idle_timeout_seconds passed without
+// activity, online users then appear away
+func (h *ChatHandler) checkIdle(now time.Time) {
+    timeout := cDefaultIdleTimeout
+    if t := rasconfig.Current().IdleTimeout; t > 0 {
+        timeout = time.Duration(t) * time.Second
+    }
+
+    if h.status.Idle || now.Sub(time.Unix(h.status.LastSeen, 0)) < timeout {
+        return
+    }
+
+    h.status.Idle = true
+    h.saveLastSeen()
+    h.updatePresence()
+}
+
+func (h *ChatHandler) onSetStatus(msg *StatusMessage) {
+    if !validStatus(msg.Status) {
+        errMsg := &ErrorMessage{
+            BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+            Type:        ricaEvents.ERROR_INVALID_STATUS,
+            Error:       "Status must be online, away, busy or invisible",
+        }
+        h.outgoingInfo.enqueue(errMsg)
+        return
+    }
+
+    // Freeze last seen at the moment the user disappears
+    if msg.Status == ricaEvents.STATUS_INVISIBLE {
+        h.saveLastSeen()
+    }
+
+    h.status.Status = msg.Status
+    h.status.Text = normalizeStatusText(msg.Text)
+    h.updatePresence()
+
+    h.outgoingInfo.enqueue(h.presenceMessage(ricaEvents.FROM_SERVER, h.status))
+}
+
+// updatePresence stores the handler's presence for member lists and
+// announces it on joined groups when what others see changed
+func (h *ChatHandler) updatePresence() {
+    if h.presence == nil {
+        return
+    }
+
+    h.presence.Set(h.id, h.status)
+
+    shown := h.status.visible()
+    shown.LastSeen = 0
+    if shown == h.announced {
+        return
+    }
+
+    h.announced = shown
+    h.announcePresence(h.joinedGroups(), h.status.visible())
+}
+
+// goOffline forgets the handler's presence and tells groups it left
+func (h *ChatHandler) goOffline(groups []string) {
+    if h.presence == nil {
+        return
+    }
+
+    h.presence.Remove(h.id)
+    h.saveLastSeen()
+
+    // Invisible users already look offline
+    if h.announced.Status == ricaEvents.STATUS_OFFLINE {
+        return
+    }
+
+    h.announcePresence(groups, Presence{
+        Status:   ricaEvents.STATUS_OFFLINE,
+        LastSeen: h.status.LastSeen,
+    })
+}
+
+func (h *ChatHandler) presenceMessage(group string, p Presence) *PresenceMessage {
+    return &PresenceMessage{
+        RecipientMessage: RecipientMessage{
+            BaseMessage: messageOf(ricaEvents.PRESENCE_REPLY),
+            To:          group,
+            From:        h.nick,
+        },
+        Presence: p,
+        User:     h.id,
+    }
+}
+
+// announcePresence sends p to the other members of groups, presence isn't
+// part of the history so it skips the chat log
+func (h *ChatHandler) announcePresence(groups []string, p Presence) {
+    for _, g := range groups {
+        msg := h.presenceMessage(g, p)
+        msg.Stamp()
+        for _, id := range h.groupInfoManager.GetUsers(g) {
+            if id != h.id {
+                h.sendTo(g, id, msg)
+            }
+        }
+
+        h.cluster.publish(g, "", msg)
+    }
+}
+
+// saveLastSeen persists the last activity of the current nick, unless the
+// user is invisible
+func (h *ChatHandler) saveLastSeen() {
+    if h.presenceStore == nil || h.status.Status == ricaEvents.STATUS_INVISIBLE {
+        return
+    }
+
+    if err := h.presenceStore.SaveLastSeen(h.nick, time.Unix(h.status.LastSeen, 0)); err != nil {
+        log.Println("Unable to save last seen of", h.nick, err)
+    }
+}
+
+// normalizeStatusText trims text and caps its length
+func normalizeStatusText(text string) string {
+    text = strings.TrimSpace(text)
+    if len(text) > cMaxStatusTextLength {
+        text = text[:cMaxStatusTextLength]
+    }
+
+    return text
+}
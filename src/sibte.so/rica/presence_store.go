@@ -0,0 +1,50 @@
+package rica
+
+import (
+    "encoding/binary"
+    "time"
+
+    "github.com/syndtr/goleveldb/leveldb"
+)
+
+// PresenceStore keeps when each nick was last active so last-seen survives
+// disconnects and restarts
+type PresenceStore struct {
+    store *leveldb.DB
+}
+
+func NewPresenceStore(path string) (*PresenceStore, error) {
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    return &PresenceStore{
+        store: db,
+    }, nil
+}
+
+// SaveLastSeen records that nick was last active at t
+func (s *PresenceStore) SaveLastSeen(nick string, t time.Time) error {
+    defer StartStopWatch(pStoreDuration.With("presence", "save")).ObserveDuration()
+
+    b := make([]byte, 8)
+    binary.BigEndian.PutUint64(b, uint64(t.Unix()))
+    return s.store.Put([]byte(nick), b, nil)
+}
+
+// LastSeen returns when nick was last active, false if nick was never seen
+func (s *PresenceStore) LastSeen(nick string) (time.Time, bool) {
+    defer StartStopWatch(pStoreDuration.With("presence", "last_seen")).ObserveDuration()
+
+    b, err := s.store.Get([]byte(nick), nil)
+    if err != nil || len(b) != 8 {
+        return time.Time{}, false
+    }
+
+    return time.Unix(int64(binary.BigEndian.Uint64(b)), 0), true
+}
+
+func (s *PresenceStore) Close() error {
+    return s.store.Close()
+}
@@ -0,0 +1,200 @@
+package rica
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "sibte.so/rasconfig"
+    "sibte.so/rica/consts"
+)
+
+const (
+    // Clients that never say hello are assumed to speak cMinProtocolVersion,
+    // the protocol from before hello existed
+    cProtocolVersion    = 2
+    cMinProtocolVersion = 1
+
+    cMaxMessageLength = 512
+
+    // How long a rejected client has to receive its error
+    cRejectFlushTimeout = 5 * time.Second
+)
+
+// enabledFeatures lists the optional features the server offers, features
+// added by the service (like gifs served next to the chat) come last
+func (h *ChatHandler) enabledFeatures() []string {
+    features := []string{}
+    if len(rasconfig.Current().UploaderConfig) > 0 {
+        features = append(features, ricaEvents.FEATURE_UPLOADS)
+    }
+
+    if h.pushNotifier != nil {
+        features = append(features, ricaEvents.FEATURE_PUSH)
+    }
+
+    if h.presence != nil {
+        features = append(features, ricaEvents.FEATURE_PRESENCE)
+    }
+
+    if h.mentionStore != nil {
+        features = append(features, ricaEvents.FEATURE_MENTIONS)
+    }
+
+    if h.sessionStore != nil {
+        features = append(features, ricaEvents.FEATURE_RESUME)
+    }
+
+    return append(features, h.extraFeatures...)
+}
+
+// Optional events and the feature a client has to list in its hello to get them
+var pFeatureEvents = map[string]string{
+    ricaEvents.MENTION_REPLY:  ricaEvents.FEATURE_MENTIONS,
+    ricaEvents.PRESENCE_REPLY: ricaEvents.FEATURE_PRESENCE,
+}
+
+// wantsEvent is false for optional events the client left out of its hello,
+// clients that never said hello get every event
+func (h *ChatHandler) wantsEvent(event string) bool {
+    feature, optional := pFeatureEvents[event]
+    if !optional {
+        return true
+    }
+
+    h.Lock()
+    features := h.clientFeatures
+    h.Unlock()
+
+    if features == nil {
+        return true
+    }
+
+    for _, f := range features {
+        if f == feature {
+            return true
+        }
+    }
+
+    return false
+}
+
+// protocolLimits collects the limits that apply to the handler's client
+func (h *ChatHandler) protocolLimits() ProtocolLimits {
+    limits := ProtocolLimits{
+        MaxMessageLength:    cMaxMessageLength,
+        MaxNickLength:       cMaxNickLength,
+        MaxStatusTextLength: cMaxStatusTextLength,
+        OutboundQueueSize:   cap(h.outgoingInfo.channel),
+    }
+
+    if h.floodControl == nil {
+        return limits
+    }
+
+    limits.RateLimits = make(map[string]rasconfig.RateLimit)
+    for action, scopes := range rasconfig.Current().RateLimits {
+        if limit, ok := scopes[rasconfig.RateScopeConnection]; ok && limit.Rate > 0 {
+            limits.RateLimits[action] = limit
+        }
+    }
+
+    return limits
+}
+
+func (h *ChatHandler) serverHello() *ServerHelloMessage {
+    return &ServerHelloMessage{
+        BaseMessage: messageOf(ricaEvents.HELLO_REPLY),
+        Version:     cProtocolVersion,
+        MinVersion:  cMinProtocolVersion,
+        Features:    h.enabledFeatures(),
+        Limits:      h.protocolLimits(),
+        ServerTime:  time.Now().UnixNano() / int64(time.Millisecond),
+    }
+}
+
+// helloVersionLabel keeps the metric label set bounded whatever clients send
+func helloVersionLabel(version int) string {
+    switch {
+    case version < cMinProtocolVersion:
+        return "unsupported"
+    case version > cProtocolVersion:
+        return "newer"
+    }
+
+    return strconv.Itoa(version)
+}
+
+// onHello negotiates the protocol version, clients older than
+// cMinProtocolVersion get an error and are disconnected. The hello's nick
+// and rooms are then handled like set-nick and join-group commands
+func (h *ChatHandler) onHello(msg *HandshakeMessage) {
+    pClientHellos.With(helloVersionLabel(msg.Version)).Inc()
+
+    if msg.Version < cMinProtocolVersion {
+        h.outgoingInfo.enqueue(&ErrorMessage{
+            BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+            Type:        ricaEvents.ERROR_UNSUPPORTED_VERSION,
+            Error: fmt.Sprintf("Protocol version %d is not supported, this server speaks %d to %d",
+                msg.Version, cMinProtocolVersion, cProtocolVersion),
+            Body: h.serverHello(),
+        })
+
+        log.Println("Rejecting client with protocol version", msg.Version, h.outgoingInfo.ip)
+        h.disconnecting = true
+        go h.flushAndStop(cRejectFlushTimeout)
+        return
+    }
+
+    h.protocolVersion = msg.Version
+    if h.protocolVersion > cProtocolVersion {
+        h.protocolVersion = cProtocolVersion
+    }
+
+    // Read by the writer, a hello without features turns optional events off
+    h.Lock()
+    h.clientFeatures = append([]string{}, msg.Features...)
+    h.Unlock()
+
+    // Queued first so it comes before the nick-set a hello may cause
+    hello := h.serverHello()
+    hello.APIToken = h.apiToken
+    h.outgoingInfo.enqueue(hello)
+
+    if msg.Nick != "" {
+        h.handleSocketMessage(&StringMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.SET_NICK_COMMAND},
+            Message:     msg.Nick,
+        })
+    }
+
+    for _, room := range msg.Rooms {
+        h.handleSocketMessage(&StringMessage{
+            BaseMessage: BaseMessage{EventName: ricaEvents.JOIN_GROUP_COMMAND},
+            Message:     room,
+        })
+    }
+}
+
+// onInvalidCommand tells the client why a message it sent was dropped
+func (h *ChatHandler) onInvalidCommand(err error) {
+    errMsg := &ErrorMessage{
+        BaseMessage: messageOf(ricaEvents.ERROR_MSG_REPLY),
+        Type:        ricaEvents.ERROR_INVALID_MESSAGE,
+        Error:       "Message is not a valid command",
+    }
+
+    if invalid, ok := err.(*InvalidCommandError); ok && invalid.Event != "" {
+        errMsg.Body = invalid.Event
+        if _, known := pEventToStructMap[invalid.Event]; known {
+            errMsg.Error = fmt.Sprintf("Malformed %s command: %v", invalid.Event, invalid.Reason)
+        } else {
+            errMsg.Type = ricaEvents.ERROR_UNKNOWN_COMMAND
+            errMsg.Error = fmt.Sprintf("Unknown command %q", invalid.Event)
+        }
+    }
+
+    pInvalidCommands.With(errMsg.Type).Inc()
+    h.outgoingInfo.enqueue(errMsg)
+}
@@ -0,0 +1,213 @@
+package rica
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "log"
+    "sync"
+    "time"
+
+    "sibte.so/raspush"
+)
+
+// PushRegistration ties a device subscription to the user (nick) it
+// notifies and the groups mentions are delivered from (empty means all).
+// LastSeen is refreshed every time the device registers again
+type PushRegistration struct {
+    raspush.Subscription
+    User      string    `json:"user"`
+    Channels  []string  `json:"channels"`
+    CreatedAt time.Time `json:"created_at"`
+    LastSeen  time.Time `json:"last_seen"`
+}
+
+// PushDevice is what clients see of a registration, the token would let
+// anyone holding it unregister the device
+type PushDevice struct {
+    Id        string    `json:"id"`
+    Platform  string    `json:"platform"`
+    Channels  []string  `json:"channels"`
+    CreatedAt time.Time `json:"created_at"`
+    LastSeen  time.Time `json:"last_seen"`
+}
+
+// DeviceId derives a stable opaque id from the device token
+func (r *PushRegistration) DeviceId() string {
+    sum := sha256.Sum256([]byte(r.Token))
+    return hex.EncodeToString(sum[:8])
+}
+
+func (r *PushRegistration) device() *PushDevice {
+    return &PushDevice{
+        Id:        r.DeviceId(),
+        Platform:  r.Platform,
+        Channels:  r.Channels,
+        CreatedAt: r.CreatedAt,
+        LastSeen:  r.LastSeen,
+    }
+}
+
+func (r *PushRegistration) watches(group string) bool {
+    if len(r.Channels) == 0 {
+        return true
+    }
+
+    for _, c := range r.Channels {
+        if c == group {
+            return true
+        }
+    }
+
+    return false
+}
+
+// PushNotifier pushes @mentions and direct messages to users that have no
+// live connection, online users receive them over their own transport
+type PushNotifier struct {
+    sync.Mutex
+    worker        *PushWorker
+    nickRegistry  *NickRegistry
+    store         *PushRegistrationStore
+    registrations map[string][]*PushRegistration
+}
+
+// NewPushNotifier creates notifier restoring registrations saved in store
+func NewPushNotifier(worker *PushWorker, nickReg *NickRegistry, store *PushRegistrationStore) (*PushNotifier, error) {
+    ret := &PushNotifier{
+        worker:        worker,
+        nickRegistry:  nickReg,
+        store:         store,
+        registrations: make(map[string][]*PushRegistration),
+    }
+
+    saved, err := store.All()
+    if err != nil {
+        return nil, err
+    }
+
+    for _, reg := range saved {
+        ret.registrations[reg.User] = append(ret.registrations[reg.User], reg)
+    }
+
+    log.Println("Restored", len(saved), "push registrations")
+    return ret, nil
+}
+
+// Register adds (or replaces) the registration for a device token, keeping
+// the original creation time of a device that registers again
+func (n *PushNotifier) Register(reg *PushRegistration) error {
+    now := time.Now().UTC()
+    reg.CreatedAt = now
+    reg.LastSeen = now
+    if existing, err := n.store.Get(reg.Token); err == nil {
+        reg.CreatedAt = existing.CreatedAt
+    }
+
+    if err := n.store.Save(reg); err != nil {
+        return err
+    }
+
+    n.Lock()
+    defer n.Unlock()
+
+    n.remove(reg.Token)
+    n.registrations[reg.User] = append(n.registrations[reg.User], reg)
+    return nil
+}
+
+// Unregister removes the registration for a device token
+func (n *PushNotifier) Unregister(token string) bool {
+    if err := n.store.Delete(token); err != nil {
+        log.Println("Unable to delete push registration", err)
+    }
+
+    n.Lock()
+    defer n.Unlock()
+
+    return n.remove(token)
+}
+
+// Registrations returns the devices registered for user
+func (n *PushNotifier) Registrations(user string) []*PushRegistration {
+    n.Lock()
+    defer n.Unlock()
+
+    return append([]*PushRegistration{}, n.registrations[user]...)
+}
+
+// Devices lists the devices registered for user without their tokens
+func (n *PushNotifier) Devices(user string) []*PushDevice {
+    regs := n.Registrations(user)
+    ret := make([]*PushDevice, len(regs))
+    for i, r := range regs {
+        ret[i] = r.device()
+    }
+
+    return ret
+}
+
+// UnregisterDevice removes the device of user with DeviceId id
+func (n *PushNotifier) UnregisterDevice(user, id string) bool {
+    for _, r := range n.Registrations(user) {
+        if r.DeviceId() == id {
+            return n.Unregister(r.Token)
+        }
+    }
+
+    return false
+}
+
+// remove drops token from the in memory index, callers hold the lock
+func (n *PushNotifier) remove(token string) bool {
+    for user, regs := range n.registrations {
+        for i, r := range regs {
+            if r.Token != token {
+                continue
+            }
+
+            regs = append(regs[:i], regs[i+1:]...)
+            if len(regs) == 0 {
+                delete(n.registrations, user)
+            } else {
+                n.registrations[user] = regs
+            }
+            return true
+        }
+    }
+
+    return false
+}
+
+// NotifyMentions pushes msg to every offline user in nicks
+func (n *PushNotifier) NotifyMentions(group string, nicks []string, msg *ChatMessage) {
+    for _, nick := range nicks {
+        n.notify(nick, group, msg)
+    }
+}
+
+// NotifyDirect pushes a direct message to user if user is offline
+func (n *PushNotifier) NotifyDirect(user string, msg *ChatMessage) bool {
+    return n.notify(user, "", msg)
+}
+
+func (n *PushNotifier) notify(user, group string, msg *ChatMessage) bool {
+    if _, online := n.nickRegistry.IdOf(user); online {
+        return false
+    }
+
+    n.Lock()
+    regs := append([]*PushRegistration(nil), n.registrations[user]...)
+    n.Unlock()
+
+    queued := false
+    for _, r := range regs {
+        if group != "" && !r.watches(group) {
+            continue
+        }
+
+        n.worker.Enqueue(r, group, msg.Identity(), msg)
+        queued = true
+    }
+
+    return queued
+}
@@ -0,0 +1,101 @@
+package rica
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+
+    "sibte.so/raspush"
+)
+
+func newTestPushNotifier(t *testing.T) (*PushNotifier, func()) {
+    dir, err := ioutil.TempDir("", "rica-push")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    store, err := NewPushRegistrationStore(dir + "/push_registrations.leveldb")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    n, err := NewPushNotifier(nil, NewNickRegistry(), store)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return n, func() {
+        store.Close()
+        os.RemoveAll(dir)
+    }
+}
+
+func TestPushDevicesHideTokens(t *testing.T) {
+    n, cleanup := newTestPushNotifier(t)
+    defer cleanup()
+
+    token := "https://push.example.com/secret-endpoint"
+    n.Register(&PushRegistration{
+        Subscription: raspush.Subscription{Platform: raspush.PlatformWebPush, Token: token, P256dh: "key", Auth: "auth"},
+        User:         "alice",
+    })
+
+    devices := n.Devices("alice")
+    if len(devices) != 1 || devices[0].Id == "" || devices[0].Platform != raspush.PlatformWebPush {
+        t.Fatalf("Unexpected devices %+v", devices)
+    }
+
+    body, _ := json.Marshal(devices)
+    for _, secret := range []string{token, "key", "auth"} {
+        if strings.Contains(string(body), secret) {
+            t.Errorf("Devices leak %q: %s", secret, body)
+        }
+    }
+
+    if n.UnregisterDevice("bob", devices[0].Id) {
+        t.Error("Removed alice's device as bob")
+    }
+
+    if !n.UnregisterDevice("alice", devices[0].Id) || len(n.Devices("alice")) != 0 {
+        t.Error("Device not removed by id")
+    }
+}
+
+func TestPushRegisterNeedsTokenOfUser(t *testing.T) {
+    n, cleanup := newTestPushNotifier(t)
+    defer cleanup()
+
+    nicks := NewNickRegistry()
+    h := &ChatHandler{id: "id-alice", apiToken: "token-alice"}
+    nicks.Register(h.id, "alice")
+    nicks.Register("id-bob", "bob")
+
+    c := &ChatService{
+        nickRegistry: nicks,
+        pushNotifier: n,
+        apiTokens:    map[string]*ChatHandler{h.apiToken: h},
+    }
+
+    for _, authorization := range []string{"", "Bearer token-alice"} {
+        body := `{"platform": "fcm", "token": "device-token", "user": "bob"}`
+        req := httptest.NewRequest("POST", "/chat/api/register", strings.NewReader(body))
+        req.Header.Set("Content-Type", "application/json")
+        if authorization != "" {
+            req.Header.Set("Authorization", authorization)
+        }
+
+        w := httptest.NewRecorder()
+        c.onPushSubscribe(w, req, nil)
+        if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+            t.Errorf("Registered for bob with %q, status %d", authorization, w.Code)
+        }
+    }
+
+    if devices := n.Devices("bob"); len(devices) != 0 {
+        t.Fatal("Devices registered for bob", devices)
+    }
+}
@@ -0,0 +1,85 @@
+package rica
+
+import (
+    "encoding/json"
+
+    "github.com/syndtr/goleveldb/leveldb"
+    "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const cPushRegistrationPrefix = "device:"
+
+// PushRegistrationStore persists device registrations keyed by token so
+// they survive server restarts
+type PushRegistrationStore struct {
+    store *leveldb.DB
+}
+
+func NewPushRegistrationStore(path string) (*PushRegistrationStore, error) {
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    return &PushRegistrationStore{
+        store: db,
+    }, nil
+}
+
+func (s *PushRegistrationStore) Get(token string) (*PushRegistration, error) {
+    defer StartStopWatch(pStoreDuration.With("push_registrations", "get")).ObserveDuration()
+
+    b, err := s.store.Get([]byte(cPushRegistrationPrefix+token), nil)
+    if err != nil {
+        return nil, err
+    }
+
+    reg := &PushRegistration{}
+    if err = json.Unmarshal(b, reg); err != nil {
+        return nil, err
+    }
+
+    return reg, nil
+}
+
+func (s *PushRegistrationStore) Save(reg *PushRegistration) error {
+    defer StartStopWatch(pStoreDuration.With("push_registrations", "save")).ObserveDuration()
+
+    b, err := json.Marshal(reg)
+    if err != nil {
+        return err
+    }
+
+    return s.store.Put([]byte(cPushRegistrationPrefix+reg.Token), b, nil)
+}
+
+func (s *PushRegistrationStore) Delete(token string) error {
+    defer StartStopWatch(pStoreDuration.With("push_registrations", "delete")).ObserveDuration()
+
+    return s.store.Delete([]byte(cPushRegistrationPrefix+token), nil)
+}
+
+// All returns every stored registration, entries that fail to decode are skipped
+func (s *PushRegistrationStore) All() ([]*PushRegistration, error) {
+    defer StartStopWatch(pStoreDuration.With("push_registrations", "all")).ObserveDuration()
+
+    ret := []*PushRegistration{}
+
+    iter := s.store.NewIterator(util.BytesPrefix([]byte(cPushRegistrationPrefix)), nil)
+    defer iter.Release()
+
+    for iter.Next() {
+        reg := &PushRegistration{}
+        if err := json.Unmarshal(iter.Value(), reg); err != nil {
+            continue
+        }
+
+        ret = append(ret, reg)
+    }
+
+    return ret, iter.Error()
+}
+
+func (s *PushRegistrationStore) Close() error {
+    return s.store.Close()
+}
@@ -0,0 +1,147 @@
+package rica
+
+import (
+    "encoding/json"
+    "log"
+    "sync"
+    "time"
+
+    "sibte.so/raspush"
+)
+
+const (
+    // Window during which recipients of the same message are batched together
+    cPushBatchWindow = 100 * time.Millisecond
+
+    // Registrations of one batch sent at the same time
+    cMaxConcurrentPushes = 16
+)
+
+type PushDeliveryWork struct {
+    Registrations []*PushRegistration
+    Group         string
+    Message       interface{}
+}
+
+type PushWorker struct {
+    sync.Mutex
+    deliveryMap map[uint64]*PushDeliveryWork
+    sender      *raspush.Sender
+    prefsStore  *NotificationPrefsStore
+}
+
+func NewPushWorker(sender *raspush.Sender, prefsStore *NotificationPrefsStore) *PushWorker {
+    return &PushWorker{
+        deliveryMap: make(map[uint64]*PushDeliveryWork),
+        sender:      sender,
+        prefsStore:  prefsStore,
+    }
+}
+
+// Enqueue adds a recipient for message id, the first recipient schedules
+// delivery of the whole batch. group is empty for direct messages
+func (g *PushWorker) Enqueue(to *PushRegistration, group string, id uint64, message interface{}) {
+    g.Lock()
+    defer g.Unlock()
+
+    work, ok := g.deliveryMap[id]
+    if !ok {
+        work = &PushDeliveryWork{
+            Registrations: []*PushRegistration{},
+            Group:         group,
+            Message:       message,
+        }
+        g.deliveryMap[id] = work
+
+        time.AfterFunc(cPushBatchWindow, func() {
+            if err := g.Deliver(id); err != nil {
+                log.Println("Push delivery failed", id, err)
+            }
+        })
+    }
+
+    work.Registrations = append(work.Registrations, to)
+}
+
+func (g *PushWorker) Deliver(id uint64) error {
+    work := g.dequeue(id)
+
+    if work == nil {
+        return nil
+    }
+
+    msg, err := json.Marshal(work.Message)
+    if err != nil {
+        return err
+    }
+
+    // Each registration retries on its own, so one throttled device doesn't
+    // hold back the rest of the batch
+    var lastErr error
+    var errLock sync.Mutex
+    var wg sync.WaitGroup
+    slots := make(chan struct{}, cMaxConcurrentPushes)
+    now := time.Now()
+    for _, reg := range work.Registrations {
+        if !g.allows(reg.User, work.Group, now) {
+            continue
+        }
+
+        wg.Add(1)
+        slots <- struct{}{}
+        go func(reg *PushRegistration) {
+            defer func() {
+                <-slots
+                wg.Done()
+            }()
+
+            if err := g.sender.Send(&reg.Subscription, msg); err != nil && err != raspush.ErrInvalidToken {
+                errLock.Lock()
+                lastErr = err
+                errLock.Unlock()
+            }
+        }(reg)
+    }
+
+    wg.Wait()
+    return lastErr
+}
+
+// allows checks the user's muted groups and quiet hours at delivery time
+func (g *PushWorker) allows(user, group string, at time.Time) bool {
+    prefs, err := g.prefsStore.Get(user)
+    if err != nil {
+        log.Println("Unable to load notification preferences for", user, err)
+        return true
+    }
+
+    return prefs.Allows(group, at)
+}
+
+// VAPIDPublicKey returns the web push application server key if configured
+func (g *PushWorker) VAPIDPublicKey() string {
+    p, ok := g.sender.Provider(raspush.PlatformWebPush)
+    if !ok {
+        return ""
+    }
+
+    if keyed, ok := p.(interface {
+        PublicKey() string
+    }); ok {
+        return keyed.PublicKey()
+    }
+
+    return ""
+}
+
+func (g *PushWorker) dequeue(id uint64) *PushDeliveryWork {
+    g.Lock()
+    defer g.Unlock()
+
+    if work, ok := g.deliveryMap[id]; ok {
+        delete(g.deliveryMap, id)
+        return work
+    }
+
+    return nil
+}
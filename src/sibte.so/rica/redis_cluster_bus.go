@@ -0,0 +1,400 @@
+package rica
+
+import (
+    "encoding/json"
+    "log"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gomodule/redigo/redis"
+)
+
+const (
+    cClusterKeyPrefix = "raspchat:"
+    cClusterChannel   = cClusterKeyPrefix + "bus"
+
+    // Nicks and memberships expire unless their node keeps refreshing them,
+    // so a crashed node doesn't hold on to them forever
+    cClusterClaimTTL        = 60 * time.Second
+    cClusterRefreshInterval = 20 * time.Second
+    cClusterReconnectDelay  = time.Second
+)
+
+// Deletes a key only if it still holds the expected value
+var pCompareAndDelete = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Extends a key's TTL only if it still holds the expected value
+var pCompareAndExpire = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("EXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// redisClusterBus implements ClusterBus on a Redis compatible server, pub/sub
+// carries messages while nicks and memberships are plain keys and sorted sets
+type redisClusterBus struct {
+    sync.Mutex
+    pool       *redis.Pool
+    nicks      map[string]string
+    groups     map[string]map[string]struct{}
+    worker     *workerClaim
+    onNickLost func(nick, id string)
+    done       chan struct{}
+    once       sync.Once
+}
+
+type workerClaim struct {
+    id    uint32
+    owner string
+}
+
+func NewRedisClusterBus(url string) (ClusterBus, error) {
+    pool := &redis.Pool{
+        MaxIdle:     8,
+        IdleTimeout: 5 * time.Minute,
+        Dial: func() (redis.Conn, error) {
+            return redis.DialURL(url)
+        },
+    }
+
+    conn := pool.Get()
+    defer conn.Close()
+    if _, err := conn.Do("PING"); err != nil {
+        pool.Close()
+        return nil, err
+    }
+
+    b := &redisClusterBus{
+        pool:   pool,
+        nicks:  make(map[string]string),
+        groups: make(map[string]map[string]struct{}),
+        done:   make(chan struct{}),
+    }
+
+    go b.refreshLoop()
+    return b, nil
+}
+
+func nickKey(nick string) string {
+    return cClusterKeyPrefix + "nick:" + nick
+}
+
+func idKey(id string) string {
+    return cClusterKeyPrefix + "id:" + id
+}
+
+func groupKey(group string) string {
+    return cClusterKeyPrefix + "group:" + group
+}
+
+func workerKey(workerId uint32) string {
+    return cClusterKeyPrefix + "worker:" + strconv.FormatUint(uint64(workerId), 10)
+}
+
+func (b *redisClusterBus) do(cmd string, args ...interface{}) (interface{}, error) {
+    conn := b.pool.Get()
+    defer conn.Close()
+
+    return conn.Do(cmd, args...)
+}
+
+func (b *redisClusterBus) Publish(env *ClusterEnvelope) error {
+    payload, err := json.Marshal(env)
+    if err != nil {
+        return err
+    }
+
+    _, err = b.do("PUBLISH", cClusterChannel, payload)
+    return err
+}
+
+func (b *redisClusterBus) Subscribe(deliver func(*ClusterEnvelope)) error {
+    go func() {
+        for {
+            if err := b.receive(deliver); err != nil {
+                log.Println("Cluster subscription lost", err)
+            }
+
+            select {
+            case <-b.done:
+                return
+            case <-time.After(cClusterReconnectDelay):
+            }
+        }
+    }()
+
+    return nil
+}
+
+func (b *redisClusterBus) receive(deliver func(*ClusterEnvelope)) error {
+    psc := redis.PubSubConn{Conn: b.pool.Get()}
+    defer psc.Close()
+
+    if err := psc.Subscribe(cClusterChannel); err != nil {
+        return err
+    }
+
+    // The watcher lives as long as this subscription, not the bus, and is
+    // gone before the connection goes back to the pool
+    stop := make(chan struct{})
+    stopped := make(chan struct{})
+    defer func() {
+        close(stop)
+        <-stopped
+    }()
+    go func() {
+        defer close(stopped)
+        select {
+        case <-b.done:
+            psc.Unsubscribe()
+        case <-stop:
+        }
+    }()
+
+    for {
+        switch v := psc.Receive().(type) {
+        case redis.Message:
+            env := &ClusterEnvelope{}
+            if err := json.Unmarshal(v.Data, env); err != nil {
+                log.Println("Invalid cluster message", err)
+                continue
+            }
+
+            deliver(env)
+        case redis.Subscription:
+            if v.Count == 0 {
+                return nil
+            }
+        case error:
+            return v
+        }
+    }
+}
+
+func (b *redisClusterBus) ClaimNick(nick, id string) (bool, error) {
+    ttl := int(cClusterClaimTTL.Seconds())
+    reply, err := redis.String(b.do("SET", nickKey(nick), id, "NX", "EX", ttl))
+    if err == redis.ErrNil {
+        owner, err := redis.String(b.do("GET", nickKey(nick)))
+        if err == redis.ErrNil {
+            // Expired between SET and GET, the next attempt may take it
+            return false, nil
+        } else if err != nil || owner != id {
+            return false, err
+        }
+    } else if err != nil || reply != "OK" {
+        return false, err
+    }
+
+    if _, err = b.do("SET", idKey(id), nick, "EX", ttl); err != nil {
+        conn := b.pool.Get()
+        pCompareAndDelete.Do(conn, nickKey(nick), id)
+        conn.Close()
+        return false, err
+    }
+
+    b.Lock()
+    b.nicks[nick] = id
+    b.Unlock()
+    return true, nil
+}
+
+func (b *redisClusterBus) ReleaseNick(nick, id string) error {
+    b.Lock()
+    if b.nicks[nick] == id {
+        delete(b.nicks, nick)
+    }
+    b.Unlock()
+
+    conn := b.pool.Get()
+    defer conn.Close()
+
+    if _, err := pCompareAndDelete.Do(conn, idKey(id), nick); err != nil {
+        return err
+    }
+
+    _, err := pCompareAndDelete.Do(conn, nickKey(nick), id)
+    return err
+}
+
+func (b *redisClusterBus) OnNickLost(fn func(nick, id string)) {
+    b.Lock()
+    b.onNickLost = fn
+    b.Unlock()
+}
+
+func (b *redisClusterBus) NickOwner(nick string) (string, bool) {
+    id, err := redis.String(b.do("GET", nickKey(nick)))
+    return id, err == nil
+}
+
+func (b *redisClusterBus) NickOf(id string) (string, bool) {
+    nick, err := redis.String(b.do("GET", idKey(id)))
+    return nick, err == nil
+}
+
+func (b *redisClusterBus) Join(group, id string) error {
+    b.Lock()
+    if b.groups[group] == nil {
+        b.groups[group] = make(map[string]struct{})
+    }
+    b.groups[group][id] = struct{}{}
+    b.Unlock()
+
+    _, err := b.do("ZADD", groupKey(group), claimExpiry(), id)
+    return err
+}
+
+func (b *redisClusterBus) Leave(group, id string) error {
+    b.Lock()
+    delete(b.groups[group], id)
+    if len(b.groups[group]) == 0 {
+        delete(b.groups, group)
+    }
+    b.Unlock()
+
+    _, err := b.do("ZREM", groupKey(group), id)
+    return err
+}
+
+func (b *redisClusterBus) Members(group string) ([]string, error) {
+    now := strconv.FormatInt(time.Now().Unix(), 10)
+    b.do("ZREMRANGEBYSCORE", groupKey(group), "-inf", "("+now)
+    return redis.Strings(b.do("ZRANGEBYSCORE", groupKey(group), now, "+inf"))
+}
+
+func (b *redisClusterBus) ClaimWorkerId(workerId uint32, owner string) (bool, error) {
+    ttl := int(cClusterClaimTTL.Seconds())
+    _, err := redis.String(b.do("SET", workerKey(workerId), owner, "NX", "EX", ttl))
+    if err == redis.ErrNil {
+        // A node restarting after a crash finds its own claim
+        holder, err := redis.String(b.do("GET", workerKey(workerId)))
+        if err != nil || holder != owner {
+            return false, err
+        }
+    } else if err != nil {
+        return false, err
+    }
+
+    b.Lock()
+    b.worker = &workerClaim{id: workerId, owner: owner}
+    b.Unlock()
+    return true, nil
+}
+
+// claimExpiry is the sorted set score after which a membership is stale
+func claimExpiry() int64 {
+    return time.Now().Add(cClusterClaimTTL).Unix()
+}
+
+// refreshLoop extends the TTL of every nick and membership held by this node
+func (b *redisClusterBus) refreshLoop() {
+    ticker := time.NewTicker(cClusterRefreshInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.done:
+            return
+        case <-ticker.C:
+        }
+
+        if err := b.refresh(); err != nil {
+            log.Println("Unable to refresh cluster claims", err)
+        }
+    }
+}
+
+func (b *redisClusterBus) refresh() error {
+    b.Lock()
+    nicks := make(map[string]string, len(b.nicks))
+    for nick, id := range b.nicks {
+        nicks[nick] = id
+    }
+    groups := make(map[string][]string, len(b.groups))
+    for group, ids := range b.groups {
+        for id := range ids {
+            groups[group] = append(groups[group], id)
+        }
+    }
+    worker := b.worker
+    b.Unlock()
+
+    conn := b.pool.Get()
+    defer conn.Close()
+
+    ttl := int(cClusterClaimTTL.Seconds())
+    if worker != nil {
+        held, err := redis.Int(pCompareAndExpire.Do(conn, workerKey(worker.id), worker.owner, ttl))
+        if err != nil {
+            return err
+        }
+
+        if held == 0 {
+            pWorkerIdCollisions.Inc()
+            log.Println("Worker id", worker.id, "is claimed by another node, message ids may collide")
+        }
+    }
+
+    // A claim that expired meanwhile may belong to someone else now, so only
+    // keys still holding our value are extended
+    for nick, id := range nicks {
+        held, err := redis.Int(pCompareAndExpire.Do(conn, nickKey(nick), id, ttl))
+        if err != nil {
+            return err
+        }
+
+        if held == 0 {
+            log.Println("Lost cluster claim on nick", nick)
+            b.Lock()
+            lost := b.nicks[nick] == id
+            if lost {
+                delete(b.nicks, nick)
+            }
+            onNickLost := b.onNickLost
+            b.Unlock()
+
+            if lost && onNickLost != nil {
+                onNickLost(nick, id)
+            }
+            continue
+        }
+
+        if _, err := pCompareAndExpire.Do(conn, idKey(id), nick, ttl); err != nil {
+            return err
+        }
+    }
+
+    expiry := claimExpiry()
+    for group, ids := range groups {
+        for _, id := range ids {
+            conn.Send("ZADD", groupKey(group), expiry, id)
+        }
+    }
+
+    _, err := conn.Do("")
+    return err
+}
+
+func (b *redisClusterBus) Close() error {
+    b.once.Do(func() {
+        close(b.done)
+    })
+
+    b.Lock()
+    worker := b.worker
+    b.Unlock()
+
+    if worker != nil {
+        conn := b.pool.Get()
+        pCompareAndDelete.Do(conn, workerKey(worker.id), worker.owner)
+        conn.Close()
+    }
+
+    return b.pool.Close()
+}
@@ -0,0 +1,182 @@
+package rica
+
+import (
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisBus(t *testing.T, mr *miniredis.Miniredis) *redisClusterBus {
+    bus, err := NewRedisClusterBus("redis://" + mr.Addr())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return bus.(*redisClusterBus)
+}
+
+func TestRedisBusClaimAndRelease(t *testing.T) {
+    mr := miniredis.RunT(t)
+    a, b := newTestRedisBus(t, mr), newTestRedisBus(t, mr)
+    defer a.Close()
+    defer b.Close()
+
+    if ok, err := a.ClaimNick("alice", "id1"); !ok || err != nil {
+        t.Fatalf("Claim failed %v %v", ok, err)
+    }
+
+    // Claiming again for the same id is fine, for another it isn't
+    if ok, _ := a.ClaimNick("alice", "id1"); !ok {
+        t.Fatal("Holder can't reclaim its nick")
+    }
+
+    if ok, err := b.ClaimNick("alice", "id2"); ok || err != nil {
+        t.Fatalf("Nick claimed twice across nodes %v %v", ok, err)
+    }
+
+    if id, ok := b.NickOwner("alice"); !ok || id != "id1" {
+        t.Fatalf("Owner %q %v", id, ok)
+    }
+
+    if nick, ok := b.NickOf("id1"); !ok || nick != "alice" {
+        t.Fatalf("Nick of id1 %q %v", nick, ok)
+    }
+
+    b.ReleaseNick("alice", "id2")
+    if _, ok := b.NickOwner("alice"); !ok {
+        t.Fatal("Nick released by someone else")
+    }
+
+    a.ReleaseNick("alice", "id1")
+    if _, ok := b.NickOwner("alice"); ok {
+        t.Fatal("Nick still owned after release")
+    }
+
+    if _, ok := b.NickOf("id1"); ok {
+        t.Fatal("Id still mapped after release")
+    }
+}
+
+func TestRedisBusClaimExpiresWithoutRefresh(t *testing.T) {
+    mr := miniredis.RunT(t)
+    a, b := newTestRedisBus(t, mr), newTestRedisBus(t, mr)
+    defer a.Close()
+    defer b.Close()
+
+    a.ClaimNick("alice", "id1")
+    mr.FastForward(cClusterClaimTTL / 2)
+    if err := a.refresh(); err != nil {
+        t.Fatal(err)
+    }
+
+    // Refreshed half way, so still held past the first TTL
+    mr.FastForward(cClusterClaimTTL/2 + time.Second)
+    if id, ok := b.NickOwner("alice"); !ok || id != "id1" {
+        t.Fatalf("Refreshed claim lost %q %v", id, ok)
+    }
+
+    mr.FastForward(cClusterClaimTTL)
+    if _, ok := b.NickOwner("alice"); ok {
+        t.Fatal("Claim didn't expire")
+    }
+
+    if ok, _ := b.ClaimNick("alice", "id2"); !ok {
+        t.Fatal("Expired nick can't be claimed")
+    }
+}
+
+// A node that lost its claim must not extend the new owner's key
+func TestRedisBusRefreshSkipsLostClaims(t *testing.T) {
+    mr := miniredis.RunT(t)
+    a, b := newTestRedisBus(t, mr), newTestRedisBus(t, mr)
+    defer a.Close()
+    defer b.Close()
+
+    var lost []string
+    a.OnNickLost(func(nick, id string) {
+        lost = append(lost, nick+" "+id)
+    })
+
+    a.ClaimNick("alice", "id1")
+    mr.FastForward(cClusterClaimTTL + time.Second)
+    b.ClaimNick("alice", "id2")
+    mr.SetTTL(nickKey("alice"), time.Second)
+
+    if err := a.refresh(); err != nil {
+        t.Fatal(err)
+    }
+
+    if ttl := mr.TTL(nickKey("alice")); ttl != time.Second {
+        t.Fatalf("New owner's claim extended to %v", ttl)
+    }
+
+    a.Lock()
+    _, held := a.nicks["alice"]
+    a.Unlock()
+    if held {
+        t.Fatal("Lost claim still refreshed")
+    }
+
+    if len(lost) != 1 || lost[0] != "alice id1" {
+        t.Fatalf("Owner notified of %v", lost)
+    }
+}
+
+func TestRedisBusMembers(t *testing.T) {
+    mr := miniredis.RunT(t)
+    a, b := newTestRedisBus(t, mr), newTestRedisBus(t, mr)
+    defer a.Close()
+    defer b.Close()
+
+    a.Join("lounge", "id1")
+    b.Join("lounge", "id2")
+    a.Leave("lounge", "id1")
+
+    members, err := a.Members("lounge")
+    if err != nil || len(members) != 1 || members[0] != "id2" {
+        t.Fatalf("Members %v %v", members, err)
+    }
+}
+
+func TestRedisBusPublishSurvivesReconnect(t *testing.T) {
+    mr := miniredis.RunT(t)
+    a, b := newTestRedisBus(t, mr), newTestRedisBus(t, mr)
+    defer a.Close()
+    defer b.Close()
+
+    received := make(chan *ClusterEnvelope, 16)
+    b.Subscribe(func(env *ClusterEnvelope) {
+        select {
+        case received <- env:
+        default:
+        }
+    })
+
+    // Messages published before the subscription is live are lost, so
+    // keep publishing until one gets through
+    expectDelivery := func(group string) {
+        t.Helper()
+
+        deadline := time.After(5 * time.Second)
+        for {
+            a.Publish(&ClusterEnvelope{Node: "a", Group: group, Type: "ChatMessage"})
+            select {
+            case env := <-received:
+                if env.Group == group {
+                    return
+                }
+            case <-time.After(50 * time.Millisecond):
+            case <-deadline:
+                t.Fatal("Envelope not delivered to", group)
+            }
+        }
+    }
+
+    expectDelivery("before")
+    mr.Close()
+    if err := mr.Restart(); err != nil {
+        t.Fatal(err)
+    }
+    expectDelivery("after")
+}
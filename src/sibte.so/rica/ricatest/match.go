@@ -0,0 +1,97 @@
+package ricatest
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Matcher narrows down which event an expectation accepts
+type Matcher interface {
+    Match(e *Event) bool
+    String() string
+}
+
+type fieldMatcher struct {
+    key   string
+    value string
+}
+
+func (m fieldMatcher) Match(e *Event) bool {
+    return e.Field(m.key) == m.value
+}
+
+func (m fieldMatcher) String() string {
+    return fmt.Sprintf("%s=%q", m.key, m.value)
+}
+
+// Field matches events whose JSON field key formats as value
+func Field(key string, value interface{}) Matcher {
+    return fieldMatcher{key: key, value: fmt.Sprint(value)}
+}
+
+// From matches events sent by nick
+func From(nick string) Matcher {
+    return Field("from", nick)
+}
+
+// To matches events addressed to a group or nick
+func To(recipient string) Matcher {
+    return Field("to", recipient)
+}
+
+// Message matches events carrying text in msg
+func Message(text string) Matcher {
+    return Field("msg", text)
+}
+
+type membersMatcher []string
+
+func (m membersMatcher) Match(e *Event) bool {
+    list, ok := e.Fields["pack_msg"].([]interface{})
+    if !ok || len(list) != len(m) {
+        return false
+    }
+
+    got := make([]string, len(list))
+    for i, v := range list {
+        got[i] = fmt.Sprint(v)
+    }
+
+    want := append([]string{}, m...)
+    sort.Strings(got)
+    sort.Strings(want)
+    return strings.Join(got, "\n") == strings.Join(want, "\n")
+}
+
+func (m membersMatcher) String() string {
+    return fmt.Sprintf("members=%v", []string(m))
+}
+
+// Members matches group-list replies naming exactly nicks, in any order
+func Members(nicks ...string) Matcher {
+    return membersMatcher(nicks)
+}
+
+func matchAll(e *Event, event string, matchers []Matcher) bool {
+    if e.Name != event {
+        return false
+    }
+
+    for _, m := range matchers {
+        if !m.Match(e) {
+            return false
+        }
+    }
+
+    return true
+}
+
+func describe(event string, matchers []Matcher) string {
+    parts := []string{event}
+    for _, m := range matchers {
+        parts = append(parts, m.String())
+    }
+
+    return strings.Join(parts, " ")
+}
@@ -0,0 +1,309 @@
+/*
+Package ricatest runs chat handlers over in memory transports so tests can
+script conversations between clients:
+
+    s := ricatest.NewScenario(t)
+    defer s.Close()
+
+    alice := s.Connect("alice")
+    bob := s.Connect("bob")
+    alice.Join("lounge")
+    bob.Join("lounge")
+    bob.Say("lounge", "hi")
+    alice.Expect(ricaEvents.GROUP_MSG_REPLY, ricatest.From("bob"), ricatest.Message("hi"))
+
+Commands return once the client's handler processed them, so every step
+sees the effects of the ones before it.
+*/
+package ricatest
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "sibte.so/rica"
+    "sibte.so/rica/consts"
+)
+
+// How long expectations wait for an event by default
+const cDefaultTimeout = 2 * time.Second
+
+// Scenario connects clients to handlers sharing one nick registry, group
+// membership and temporary chat log
+type Scenario struct {
+    T         testing.TB
+    Timeout   time.Duration
+    Nicks     *rica.NickRegistry
+    Groups    rica.GroupInfoManager
+    Store     *rica.ChatLogStore
+    BlackList *rica.BlackList
+    clients   []*Client
+    syncs     int
+    cleanup   func()
+}
+
+// NewScenario creates an empty Scenario, Close it when the test ends
+func NewScenario(t testing.TB) *Scenario {
+    store, cleanup := TempChatLogStore(t)
+    return &Scenario{
+        T:         t,
+        Timeout:   cDefaultTimeout,
+        Nicks:     rica.NewNickRegistry(),
+        Groups:    rica.NewInMemoryGroupInfo(),
+        Store:     store,
+        BlackList: rica.NewBlackList(),
+        cleanup:   cleanup,
+    }
+}
+
+// Connect starts a handler for a new client and waits for its welcome, a
+// non empty nick is then requested with set-nick
+func (s *Scenario) Connect(nick string) *Client {
+    s.T.Helper()
+
+    trans := NewTransport()
+    c := &Client{
+        s:        s,
+        trans:    trans,
+        handler:  rica.NewChatHandler(s.Nicks, s.Groups, trans, s.Store, fmt.Sprintf("127.0.0.1:%d", 1000+len(s.clients)), s.BlackList),
+        consumed: make(map[int]bool),
+    }
+    s.clients = append(s.clients, c)
+    go c.handler.Loop()
+
+    c.Expect(ricaEvents.SET_NICK_REPLY)
+    if nick != "" {
+        c.SetNick(nick)
+    }
+
+    return c
+}
+
+// History returns the messages saved for group, newest first
+func (s *Scenario) History(group string) []rica.IEventMessage {
+    s.T.Helper()
+
+    msgs, err := s.Store.GetMessagesFor(group, "", 0, 1000)
+    if err != nil {
+        s.T.Fatal(err)
+    }
+
+    return msgs
+}
+
+// Close stops every client still connected and removes the chat log
+func (s *Scenario) Close() {
+    for _, c := range s.clients {
+        c.handler.Stop()
+        select {
+        case <-c.handler.Done():
+        case <-time.After(s.Timeout):
+            s.T.Error("Handler did not stop")
+        }
+    }
+
+    s.cleanup()
+}
+
+// Client is one connection of a Scenario. Events written to it are kept
+// until an expectation consumes them
+type Client struct {
+    s        *Scenario
+    trans    *Transport
+    handler  *rica.ChatHandler
+    consumed map[int]bool
+}
+
+// Handler returns the client's handler
+func (c *Client) Handler() *rica.ChatHandler {
+    return c.handler
+}
+
+// Transport returns the client's transport
+func (c *Client) Transport() *Transport {
+    return c.trans
+}
+
+// Nick returns the nick the server last assigned to the client
+func (c *Client) Nick() string {
+    events, _ := c.trans.Events()
+    for i := len(events) - 1; i >= 0; i-- {
+        if events[i].Name == ricaEvents.SET_NICK_REPLY {
+            return events[i].Field("newNick")
+        }
+    }
+
+    return ""
+}
+
+// Send writes a raw JSON command without waiting for it to be handled, false
+// if the connection is already closed
+func (c *Client) Send(command string) bool {
+    return c.trans.Send([]byte(command))
+}
+
+// Command sends event with fields and waits until it was handled
+func (c *Client) Command(event string, fields map[string]interface{}) {
+    c.s.T.Helper()
+
+    msg := map[string]interface{}{"@": event}
+    for k, v := range fields {
+        msg[k] = v
+    }
+
+    body, err := json.Marshal(msg)
+    if err != nil {
+        c.s.T.Fatal(err)
+    }
+
+    if !c.trans.Send(body) {
+        c.s.T.Fatalf("%s: connection closed before %s", c.Nick(), body)
+    }
+
+    c.Sync()
+}
+
+// Sync waits until the handler processed every command sent before and
+// wrote everything queued for the client until then
+func (c *Client) Sync() {
+    c.s.T.Helper()
+
+    c.s.syncs++
+    group := fmt.Sprintf("ricatest-sync-%d", c.s.syncs)
+    if !c.Send(fmt.Sprintf(`{"@": %q, "msg": %q}`, ricaEvents.LIST_MEMBERS_COMMAND, group)) {
+        c.s.T.Fatalf("%s: connection closed", c.Nick())
+    }
+
+    c.Expect(ricaEvents.LIST_MEMBERS_REPLY, To(group))
+}
+
+// Hello states the client's protocol version and features, the server
+// replies with server-hello or an error-msg
+func (c *Client) Hello(version int, features ...string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.HELLO_COMMAND, map[string]interface{}{"version": version, "features": features})
+}
+
+// Join joins group
+func (c *Client) Join(group string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.JOIN_GROUP_COMMAND, map[string]interface{}{"msg": group})
+}
+
+// Leave leaves group
+func (c *Client) Leave(group string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.LEAVE_GROUP_COMMAND, map[string]interface{}{"msg": group})
+}
+
+// SetNick asks for nick, the server may assign a variation of it
+func (c *Client) SetNick(nick string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.SET_NICK_COMMAND, map[string]interface{}{"msg": nick})
+}
+
+// Say sends text to group, or to a user when to is @nick
+func (c *Client) Say(to, text string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.SEND_MSG_COMMAND, map[string]interface{}{"to": to, "msg": text})
+}
+
+// SetStatus sets the client's presence status and text
+func (c *Client) SetStatus(status, text string) {
+    c.s.T.Helper()
+    c.Command(ricaEvents.SET_STATUS_COMMAND, map[string]interface{}{"status": status, "text": text})
+}
+
+// List asks for the members of group and returns the reply
+func (c *Client) List(group string) *Event {
+    c.s.T.Helper()
+
+    c.Command(ricaEvents.LIST_MEMBERS_COMMAND, map[string]interface{}{"msg": group})
+    if group == "" {
+        group = ricaEvents.FROM_SERVER
+    }
+
+    return c.Expect(ricaEvents.LIST_MEMBERS_REPLY, To(group))
+}
+
+// Hangup drops the connection from the client side and waits until the
+// handler cleaned up
+func (c *Client) Hangup() {
+    c.s.T.Helper()
+
+    c.trans.Hangup()
+    c.WaitClosed()
+}
+
+// WaitClosed waits until the handler stopped and cleaned up
+func (c *Client) WaitClosed() {
+    c.s.T.Helper()
+
+    select {
+    case <-c.handler.Done():
+    case <-time.After(c.s.Timeout):
+        c.s.T.Fatalf("%s: handler still running", c.Nick())
+    }
+}
+
+// find returns the index of the first event not consumed yet matching
+// event and matchers, -1 if there is none
+func (c *Client) find(events []*Event, event string, matchers []Matcher) int {
+    for i, e := range events {
+        if !c.consumed[i] && matchAll(e, event, matchers) {
+            return i
+        }
+    }
+
+    return -1
+}
+
+// pending lists the events not consumed yet for failure messages
+func (c *Client) pending(events []*Event) string {
+    lines := []string{}
+    for i, e := range events {
+        if !c.consumed[i] {
+            lines = append(lines, "    "+e.String())
+        }
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+// Expect waits for an event matching event and matchers and consumes it.
+// Events that don't match stay around for later expectations
+func (c *Client) Expect(event string, matchers ...Matcher) *Event {
+    c.s.T.Helper()
+
+    timeout := time.After(c.s.Timeout)
+    for {
+        events, written := c.trans.Events()
+        if i := c.find(events, event, matchers); i >= 0 {
+            c.consumed[i] = true
+            return events[i]
+        }
+
+        select {
+        case <-written:
+        case <-timeout:
+            c.s.T.Fatalf("%s: expected %s, pending events:\n%s", c.Nick(), describe(event, matchers), c.pending(events))
+            return nil
+        }
+    }
+}
+
+// ExpectNo fails if the client got an event matching event and matchers
+// that wasn't consumed yet, it syncs first so everything already sent to the
+// client has arrived
+func (c *Client) ExpectNo(event string, matchers ...Matcher) {
+    c.s.T.Helper()
+
+    c.Sync()
+    events, _ := c.trans.Events()
+    if i := c.find(events, event, matchers); i >= 0 {
+        c.s.T.Fatalf("%s: unexpected %s", c.Nick(), events[i])
+    }
+}
@@ -0,0 +1,30 @@
+package ricatest
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "sibte.so/rica"
+)
+
+// TempChatLogStore opens a ChatLogStore in a new temporary directory, cleanup
+// closes it and removes the directory
+func TempChatLogStore(t testing.TB) (store *rica.ChatLogStore, cleanup func()) {
+    dir, err := ioutil.TempDir("", "ricatest")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    store, err = rica.NewChatLogStore(filepath.Join(dir, "chats.leveldb"))
+    if err != nil {
+        os.RemoveAll(dir)
+        t.Fatal(err)
+    }
+
+    return store, func() {
+        store.Close()
+        os.RemoveAll(dir)
+    }
+}
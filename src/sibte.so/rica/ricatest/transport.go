@@ -0,0 +1,190 @@
+package ricatest
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sync"
+
+    "sibte.so/rica"
+)
+
+// Event is a message written to a client, decoded back from its JSON
+type Event struct {
+    Name   string
+    Fields map[string]interface{}
+    Raw    []byte
+}
+
+// Field returns the JSON field key of the event formatted as a string
+func (e *Event) Field(key string) string {
+    v, ok := e.Fields[key]
+    if !ok || v == nil {
+        return ""
+    }
+
+    return fmt.Sprint(v)
+}
+
+func (e *Event) String() string {
+    return string(e.Raw)
+}
+
+// Transport is an in memory rica.IMessageTransport. Commands are queued as
+// JSON and decoded like a network transport would, everything the handler
+// writes is encoded to JSON and kept in order
+type Transport struct {
+    sync.Mutex
+    incoming  chan []byte
+    hangup    chan struct{}
+    closed    chan struct{}
+    written   chan struct{}
+    stall     chan struct{}
+    events    []*Event
+    closeOnce sync.Once
+    hangOnce  sync.Once
+    closes    int
+    writeErr  error
+}
+
+// NewTransport creates a connected Transport
+func NewTransport() *Transport {
+    return &Transport{
+        incoming: make(chan []byte),
+        hangup:   make(chan struct{}),
+        closed:   make(chan struct{}),
+        written:  make(chan struct{}),
+    }
+}
+
+func (t *Transport) ReadMessage() (rica.IEventMessage, error) {
+    select {
+    case line := <-t.incoming:
+        return rica.DecodeMessage(line)
+    case <-t.hangup:
+        return nil, io.EOF
+    case <-t.closed:
+        return nil, io.ErrClosedPipe
+    }
+}
+
+func (t *Transport) WriteMessage(id uint64, msg rica.IEventMessage) error {
+    body, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+
+    event := &Event{Name: msg.Event(), Raw: body}
+    if err = json.Unmarshal(body, &event.Fields); err != nil {
+        return err
+    }
+
+    t.Lock()
+    stall := t.stall
+    t.Unlock()
+
+    if stall != nil {
+        select {
+        case <-stall:
+        case <-t.closed:
+            return io.ErrClosedPipe
+        }
+    }
+
+    t.Lock()
+    defer t.Unlock()
+
+    if t.writeErr != nil {
+        return t.writeErr
+    }
+
+    t.events = append(t.events, event)
+
+    // Wake everyone waiting for the next event
+    close(t.written)
+    t.written = make(chan struct{})
+    return nil
+}
+
+func (t *Transport) BeginBatch(id uint64, msg rica.IEventMessage) {
+}
+
+func (t *Transport) FlushBatch(id uint64) {
+}
+
+func (t *Transport) Close() {
+    t.Lock()
+    t.closes++
+    t.Unlock()
+
+    t.closeOnce.Do(func() {
+        close(t.closed)
+    })
+}
+
+// Send hands a JSON command to the handler, false if the transport was
+// closed before the handler read it
+func (t *Transport) Send(command []byte) bool {
+    select {
+    case t.incoming <- command:
+        return true
+    case <-t.closed:
+        return false
+    }
+}
+
+// Hangup makes reads fail as if the peer went away
+func (t *Transport) Hangup() {
+    t.hangOnce.Do(func() {
+        close(t.hangup)
+    })
+}
+
+// FailWrites makes every following write return err
+func (t *Transport) FailWrites(err error) {
+    t.Lock()
+    defer t.Unlock()
+
+    t.writeErr = err
+}
+
+// StallWrites holds every write until release is called or the transport
+// is closed, like a client that stopped reading
+func (t *Transport) StallWrites() (release func()) {
+    stall := make(chan struct{})
+
+    t.Lock()
+    t.stall = stall
+    t.Unlock()
+
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            close(stall)
+        })
+    }
+}
+
+// Closed is closed once the handler closed the transport
+func (t *Transport) Closed() <-chan struct{} {
+    return t.closed
+}
+
+// CloseCount is the number of times Close was called
+func (t *Transport) CloseCount() int {
+    t.Lock()
+    defer t.Unlock()
+
+    return t.closes
+}
+
+// Events returns a copy of everything written so far along with a channel
+// closed on the next write
+func (t *Transport) Events() ([]*Event, <-chan struct{}) {
+    t.Lock()
+    defer t.Unlock()
+
+    ret := make([]*Event, len(t.events))
+    copy(ret, t.events)
+    return ret, t.written
+}
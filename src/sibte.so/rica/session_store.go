@@ -0,0 +1,67 @@
+package rica
+
+import (
+    "encoding/json"
+    "time"
+
+    "github.com/syndtr/goleveldb/leveldb"
+)
+
+// Resume tokens handed out on restart stay valid this long
+const cResumableSessionTTL = 5 * time.Minute
+
+// ResumableSession is the state a client gets back with its resume token
+type ResumableSession struct {
+    Nick    string    `json:"nick"`
+    Groups  []string  `json:"groups"`
+    Expires time.Time `json:"expires"`
+}
+
+// SessionStore keeps resumable sessions across process restarts
+type SessionStore struct {
+    store *leveldb.DB
+}
+
+func NewSessionStore(path string) (*SessionStore, error) {
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    return &SessionStore{
+        store: db,
+    }, nil
+}
+
+func (s *SessionStore) Save(token string, session *ResumableSession) error {
+    defer StartStopWatch(pStoreDuration.With("sessions", "save")).ObserveDuration()
+
+    b, err := json.Marshal(session)
+    if err != nil {
+        return err
+    }
+
+    return s.store.Put([]byte(token), b, nil)
+}
+
+// Take returns and forgets the session saved under token, tokens are single use
+func (s *SessionStore) Take(token string) (*ResumableSession, bool) {
+    defer StartStopWatch(pStoreDuration.With("sessions", "take")).ObserveDuration()
+
+    b, err := s.store.Get([]byte(token), nil)
+    if err != nil {
+        return nil, false
+    }
+    s.store.Delete([]byte(token), nil)
+
+    session := &ResumableSession{}
+    if err = json.Unmarshal(b, session); err != nil || time.Now().After(session.Expires) {
+        return nil, false
+    }
+
+    return session, true
+}
+
+func (s *SessionStore) Close() error {
+    return s.store.Close()
+}
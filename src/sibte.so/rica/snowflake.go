@@ -0,0 +1,198 @@
+package rica
+
+// Credits to https://github.com/sdming/gosnow for implementing snowflake
+
+import (
+    "fmt"
+    "hash/crc32"
+    "math/rand"
+    "net"
+    "os"
+    "sync"
+    "time"
+)
+
+const (
+    nano = 1000 * 1000
+
+    // Clock regressions up to this many milliseconds are waited out
+    cMaxClockWaitMillis = 10
+)
+
+const (
+    WorkerIdBits = 10              // worker id
+    MaxWorkerId  = -1 ^ (-1 << 10) // worker id mask
+    SequenceBits = 12              // sequence
+    MaxSequence  = -1 ^ (-1 << 12) //sequence mask
+)
+
+var (
+    Since int64 = time.Date(2012, 1, 0, 0, 0, 0, 0, time.UTC).UnixNano() / nano
+)
+
+type SnowFlake struct {
+    lastTimestamp uint64
+    workerId      uint32
+    sequence      uint32
+    lock          sync.Mutex
+}
+
+func (sf *SnowFlake) uint64() uint64 {
+    return (sf.lastTimestamp << (WorkerIdBits + SequenceBits)) |
+        (uint64(sf.workerId) << SequenceBits) |
+        (uint64(sf.sequence))
+}
+
+// Next returns a new id. When the clock jumps back by up to
+// cMaxClockWaitMillis it waits for the clock to catch up, larger jumps keep
+// counting from the last timestamp, borrowing sequence space from the
+// milliseconds ahead, so ids never repeat or go backwards
+func (sf *SnowFlake) Next() uint64 {
+    sf.lock.Lock()
+    defer sf.lock.Unlock()
+
+    ts := timestamp()
+    if ts < sf.lastTimestamp {
+        if sf.lastTimestamp-ts <= cMaxClockWaitMillis {
+            pClockRegressions.With("wait").Inc()
+            time.Sleep(time.Duration(sf.lastTimestamp-ts) * time.Millisecond)
+            ts = tilMillis(sf.lastTimestamp)
+        } else {
+            pClockRegressions.With("borrow").Inc()
+            ts = sf.lastTimestamp
+        }
+    }
+
+    if ts == sf.lastTimestamp {
+        sf.sequence = (sf.sequence + 1) & MaxSequence
+        if sf.sequence == 0 {
+            pSequenceExhausted.Inc()
+            if timestamp() < ts {
+                // Still behind, borrow the next millisecond
+                ts++
+            } else {
+                ts = tilMillis(ts + 1)
+            }
+        }
+    } else {
+        sf.sequence = 0
+    }
+
+    sf.lastTimestamp = ts
+    return sf.uint64()
+}
+
+// SetWorkerId changes the worker id, nodes of a cluster need distinct ids
+// so their message ids never collide
+func (sf *SnowFlake) SetWorkerId(workerId uint32) error {
+    if workerId > MaxWorkerId {
+        return fmt.Errorf("Worker id %v is invalid", workerId)
+    }
+
+    sf.lock.Lock()
+    defer sf.lock.Unlock()
+
+    sf.workerId = workerId
+    return nil
+}
+
+func DefaultSnowFlake() *SnowFlake {
+    ins, err := NewSnowFlake(DefaultWorkId())
+    if err != nil {
+        return nil
+    }
+
+    return ins
+}
+
+func NewSnowFlake(workerId uint32) (*SnowFlake, error) {
+    if workerId < 0 || workerId > MaxWorkerId {
+        return nil, fmt.Errorf("Worker id %v is invalid", workerId)
+    }
+    return &SnowFlake{workerId: workerId}, nil
+}
+
+// SnowFlakeParts are the fields packed into a snowflake id
+type SnowFlakeParts struct {
+    Time     time.Time `json:"time"`
+    WorkerId uint32    `json:"worker_id"`
+    Sequence uint32    `json:"sequence"`
+}
+
+// DecodeSnowFlake splits id into its time, worker id and sequence
+func DecodeSnowFlake(id uint64) SnowFlakeParts {
+    return SnowFlakeParts{
+        Time:     SnowFlakeTime(id),
+        WorkerId: uint32(id>>SequenceBits) & MaxWorkerId,
+        Sequence: uint32(id) & MaxSequence,
+    }
+}
+
+// SnowFlakeTime returns the millisecond id was generated in
+func SnowFlakeTime(id uint64) time.Time {
+    ms := int64(id>>(WorkerIdBits+SequenceBits)) + Since
+    return time.Unix(0, ms*nano).UTC()
+}
+
+// FirstSnowFlakeAt returns the smallest id that can be generated at t,
+// times before the epoch give 0
+func FirstSnowFlakeAt(t time.Time) uint64 {
+    ms := t.UnixNano()/nano - Since
+    if ms < 0 {
+        return 0
+    }
+
+    return uint64(ms) << (WorkerIdBits + SequenceBits)
+}
+
+// LastSnowFlakeAt returns the largest id that can be generated at t
+func LastSnowFlakeAt(t time.Time) uint64 {
+    ms := t.UnixNano()/nano - Since
+    if ms < 0 {
+        return 0
+    }
+
+    return (uint64(ms)+1)<<(WorkerIdBits+SequenceBits) - 1
+}
+
+func timestamp() uint64 {
+    return uint64(time.Now().UnixNano()/nano - Since)
+}
+
+// tilMillis spins until the clock reaches ts
+func tilMillis(ts uint64) uint64 {
+    i := timestamp()
+    for i < ts {
+        i = timestamp()
+    }
+    return i
+}
+
+// DefaultWorkId derives a worker id from the host name and MAC addresses,
+// containers can still end up sharing one so clusters should set worker_id
+func DefaultWorkId() uint32 {
+    var id uint32
+    ift, err := net.Interfaces()
+    if err != nil {
+        rand.Seed(time.Now().UnixNano())
+        id = rand.Uint32()
+    } else {
+        h := crc32.NewIEEE()
+        if hostname, err := os.Hostname(); err == nil {
+            h.Write([]byte(hostname))
+        }
+        for _, value := range ift {
+            h.Write(value.HardwareAddr)
+        }
+        id = h.Sum32()
+    }
+    return id & MaxWorkerId
+}
+
+// WorkerId returns the worker id embedded in generated ids
+func (sf *SnowFlake) WorkerId() uint32 {
+    sf.lock.Lock()
+    defer sf.lock.Unlock()
+
+    return sf.workerId
+}
@@ -0,0 +1,112 @@
+package rica
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+)
+
+var errSSENotSupported = errors.New("Streaming not supported by response writer")
+
+// SSEMessageTransport pushes events to the client over a Server-Sent Events
+// stream and receives commands posted by the client for the same session
+type SSEMessageTransport struct {
+    sessionId string
+    incoming  chan []byte
+    outgoing  chan IEventMessage
+    closed    chan struct{}
+    closeOnce *sync.Once
+}
+
+func NewSSEMessageTransport(sessionId string) *SSEMessageTransport {
+    return &SSEMessageTransport{
+        sessionId: sessionId,
+        incoming:  make(chan []byte, 32),
+        outgoing:  make(chan IEventMessage, 32),
+        closed:    make(chan struct{}),
+        closeOnce: &sync.Once{},
+    }
+}
+
+func (h *SSEMessageTransport) ReadMessage() (IEventMessage, error) {
+    select {
+    case msg := <-h.incoming:
+        return transportDecodeMessage(msg)
+    case <-h.closed:
+        return nil, io.EOF
+    }
+}
+
+func (h *SSEMessageTransport) WriteMessage(id uint64, msg IEventMessage) error {
+    select {
+    case h.outgoing <- msg:
+        return nil
+    case <-h.closed:
+        return io.ErrClosedPipe
+    }
+}
+
+func (h *SSEMessageTransport) BeginBatch(id uint64, msg IEventMessage) {
+}
+
+func (h *SSEMessageTransport) FlushBatch(id uint64) {
+}
+
+// PostMessage hands a raw command received over HTTP to the reader side
+func (h *SSEMessageTransport) PostMessage(msg []byte) error {
+    select {
+    case h.incoming <- msg:
+        return nil
+    case <-h.closed:
+        return io.ErrClosedPipe
+    }
+}
+
+func (h *SSEMessageTransport) Close() {
+    h.closeOnce.Do(func() {
+        close(h.closed)
+    })
+}
+
+// Serve streams outgoing messages on w until done is closed or a write fails
+func (h *SSEMessageTransport) Serve(w http.ResponseWriter, done <-chan struct{}) error {
+    defer h.Close()
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        return errSSENotSupported
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    if _, err := fmt.Fprintf(w, "event: session\ndata: %s\n\n", h.sessionId); err != nil {
+        return err
+    }
+    flusher.Flush()
+
+    for {
+        select {
+        case msg := <-h.outgoing:
+            body, err := json.Marshal(msg)
+            if err != nil {
+                return err
+            }
+
+            if _, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.Identity(), body); err != nil {
+                return err
+            }
+            flusher.Flush()
+        case <-done:
+            return nil
+        case <-h.closed:
+            return nil
+        }
+    }
+}
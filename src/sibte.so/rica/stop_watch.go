@@ -0,0 +1,35 @@
+package rica
+
+import (
+    "time"
+
+    "sibte.so/rasmetrics"
+)
+
+type StopWatch struct {
+    start, stop time.Time
+    histogram   *rasmetrics.Histogram
+}
+
+// StartStopWatch starts timing an operation recorded on histogram
+func StartStopWatch(histogram *rasmetrics.Histogram) *StopWatch {
+    return &StopWatch{
+        histogram: histogram,
+        start:     time.Now(),
+    }
+}
+
+func (self *StopWatch) milliseconds() uint32 {
+    return uint32(self.stop.Sub(self.start) / time.Millisecond)
+}
+
+func (self *StopWatch) Stop() uint32 {
+    self.stop = time.Now()
+    return self.milliseconds()
+}
+
+// ObserveDuration stops the watch and records the elapsed seconds
+func (self *StopWatch) ObserveDuration() {
+    self.Stop()
+    self.histogram.Observe(self.stop.Sub(self.start).Seconds())
+}
@@ -0,0 +1,58 @@
+package rica
+
+import (
+    "log"
+    "net"
+
+    "sibte.so/rasrate"
+)
+
+// ServeIRC attaches every IRC client accepted on listener to a chat handler
+// sharing groups, nicks and history with web clients
+func (c *ChatService) ServeIRC(listener net.Listener) error {
+    return c.serveConnections(listener, func(conn net.Conn) IMessageTransport {
+        return NewIRCMessageTransport(conn)
+    })
+}
+
+// ServeJSON serves newline delimited JSON connections accepted on listener,
+// wrap it with tls.NewListener for TLS
+func (c *ChatService) ServeJSON(listener net.Listener) error {
+    return c.serveConnections(listener, func(conn net.Conn) IMessageTransport {
+        return NewLineJSONMessageTransport(conn)
+    })
+}
+
+func (c *ChatService) serveConnections(listener net.Listener, newTransport func(net.Conn) IMessageTransport) error {
+    defer listener.Close()
+
+    c.Lock()
+    c.listeners = append(c.listeners, listener)
+    c.Unlock()
+
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            return err
+        }
+
+        ip := conn.RemoteAddr().String()
+        if c.blackList.Contains(rasrate.HostOf(ip)) {
+            conn.Close()
+            continue
+        }
+
+        if !c.connections.TryAcquire(rasrate.HostOf(ip)) {
+            log.Println("Too many connections from", ip)
+            conn.Close()
+            continue
+        }
+
+        transporter := newTransport(conn)
+        handler := c.newChatHandler(transporter, ip)
+        go func() {
+            defer conn.Close()
+            c.runChatHandler(handler, ip)
+        }()
+    }
+}
@@ -0,0 +1,72 @@
+package rica
+
+import (
+    "encoding/json"
+    "errors"
+    "reflect"
+    "sync"
+
+    "sibte.so/rica/consts"
+)
+
+var pEventToStructMap map[string]reflect.Type
+var pEventTypesOnce sync.Once
+
+func initChatHandlerTypes() {
+    pEventTypesOnce.Do(func() {
+        pEventToStructMap = make(map[string]reflect.Type)
+        pEventToStructMap[ricaEvents.SEND_MSG_COMMAND] = reflect.TypeOf(ChatMessage{})
+        pEventToStructMap[ricaEvents.JOIN_GROUP_COMMAND] = reflect.TypeOf(StringMessage{})
+        pEventToStructMap[ricaEvents.LEAVE_GROUP_COMMAND] = reflect.TypeOf(StringMessage{})
+        pEventToStructMap[ricaEvents.SET_NICK_COMMAND] = reflect.TypeOf(StringMessage{})
+        pEventToStructMap[ricaEvents.LIST_MEMBERS_COMMAND] = reflect.TypeOf(StringMessage{})
+        pEventToStructMap[ricaEvents.RESUME_COMMAND] = reflect.TypeOf(StringMessage{})
+        pEventToStructMap[ricaEvents.SET_STATUS_COMMAND] = reflect.TypeOf(StatusMessage{})
+        pEventToStructMap[ricaEvents.NEW_RAW_MSG_REPLY] = reflect.TypeOf(RecipientContentMessage{})
+        pEventToStructMap[ricaEvents.PING_REPLY] = reflect.TypeOf(PingMessage{})
+        pEventToStructMap[ricaEvents.HELLO_COMMAND] = reflect.TypeOf(HandshakeMessage{})
+    })
+}
+
+// DecodeMessage parses a JSON client command into its message type the same
+// way the transports do
+func DecodeMessage(msg []byte) (IEventMessage, error) {
+    initChatHandlerTypes()
+    return transportDecodeMessage(msg)
+}
+
+// InvalidCommandError is returned for messages that aren't a command the
+// server knows, Event is empty if the message couldn't be parsed at all.
+// Like every unreadable message its text is ERROR_INVALID_MSGTYPE_ERR
+type InvalidCommandError struct {
+    Event  string
+    Reason error
+}
+
+func (e *InvalidCommandError) Error() string {
+    return ricaEvents.ERROR_INVALID_MSGTYPE_ERR
+}
+
+func transportDecodeMessage(msg []byte) (ret IEventMessage, rErr error) {
+    eventMsg := &BaseMessage{}
+    if err := json.Unmarshal(msg, eventMsg); err != nil {
+        return nil, &InvalidCommandError{Reason: err}
+    }
+
+    var mType reflect.Type
+    var ok bool
+    if mType, ok = pEventToStructMap[eventMsg.EventName]; !ok {
+        return nil, &InvalidCommandError{
+            Event:  eventMsg.EventName,
+            Reason: errors.New("Invalid message type"),
+        }
+    }
+
+    ret = reflect.New(mType).Interface().(IEventMessage)
+    if err := json.Unmarshal(msg, ret); err != nil {
+        return nil, &InvalidCommandError{Event: eventMsg.EventName, Reason: err}
+    }
+
+    ret.Stamp()
+    return
+}
@@ -0,0 +1,54 @@
+package rica
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "sync"
+)
+
+// IPostableTransport is a transport that receives client commands out of band
+// (e.g. HTTP POST) and ties them to a running ChatHandler via a session id
+type IPostableTransport interface {
+    IMessageTransport
+    PostMessage(msg []byte) error
+}
+
+type transportSessionContainer struct {
+    sync.Mutex
+    sessions map[string]IPostableTransport
+}
+
+var pTransportSessions *transportSessionContainer = &transportSessionContainer{
+    sessions: make(map[string]IPostableTransport),
+}
+
+func newTransportSessionId() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+
+    return hex.EncodeToString(b), nil
+}
+
+func (p *transportSessionContainer) save(id string, t IPostableTransport) {
+    p.Lock()
+    defer p.Unlock()
+
+    p.sessions[id] = t
+}
+
+func (p *transportSessionContainer) get(id string) (IPostableTransport, bool) {
+    p.Lock()
+    defer p.Unlock()
+
+    t, ok := p.sessions[id]
+    return t, ok
+}
+
+func (p *transportSessionContainer) delete(id string) {
+    p.Lock()
+    defer p.Unlock()
+
+    delete(p.sessions, id)
+}
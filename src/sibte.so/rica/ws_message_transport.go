@@ -0,0 +1,105 @@
+package rica
+
+import (
+    "errors"
+    "sync"
+    "time"
+
+    "sibte.so/rica/consts"
+
+    "github.com/gorilla/websocket"
+)
+
+const (
+    // Control pings keep browsers answering even when the page is frozen,
+    // a peer silent for cWebsocketReadTimeout is considered gone
+    cWebsocketPingInterval = 15 * time.Second
+    cWebsocketReadTimeout  = 60 * time.Second
+    cWebsocketWriteTimeout = 10 * time.Second
+)
+
+type WebsocketMessageTransport struct {
+    connection          *websocket.Conn
+    connectionReadLock  *sync.Mutex
+    connectionWriteLock *sync.Mutex
+    closed              chan struct{}
+    closeOnce           sync.Once
+}
+
+func NewWebsocketMessageTransport(conn *websocket.Conn) *WebsocketMessageTransport {
+    t := &WebsocketMessageTransport{
+        connection:          conn,
+        connectionReadLock:  &sync.Mutex{},
+        connectionWriteLock: &sync.Mutex{},
+        closed:              make(chan struct{}),
+    }
+
+    conn.SetPongHandler(func(string) error {
+        return conn.SetReadDeadline(time.Now().Add(cWebsocketReadTimeout))
+    })
+
+    go t.pingLoop()
+    return t
+}
+
+// pingLoop sends control pings until the transport is closed
+func (h *WebsocketMessageTransport) pingLoop() {
+    ticker := time.NewTicker(cWebsocketPingInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-h.closed:
+            return
+        case <-ticker.C:
+        }
+
+        deadline := time.Now().Add(cWebsocketWriteTimeout)
+        if err := h.connection.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+            return
+        }
+    }
+}
+
+func (h *WebsocketMessageTransport) ReadMessage() (IEventMessage, error) {
+    h.connectionReadLock.Lock()
+    h.connection.SetReadDeadline(time.Now().Add(cWebsocketReadTimeout))
+    msgType, msg, err := h.connection.ReadMessage()
+    h.connectionReadLock.Unlock()
+
+    if err != nil {
+        return nil, err
+    }
+
+    if msgType != websocket.TextMessage {
+        return nil, errors.New(ricaEvents.ERROR_INVALID_MSGTYPE_ERR)
+    }
+
+    return transportDecodeMessage(msg)
+}
+
+func (h *WebsocketMessageTransport) WriteMessage(id uint64, msg IEventMessage) error {
+    return h.writeMessageOnSocket(msg)
+}
+
+func (h *WebsocketMessageTransport) writeMessageOnSocket(msg IEventMessage) error {
+    h.connectionWriteLock.Lock()
+    defer h.connectionWriteLock.Unlock()
+
+    h.connection.SetWriteDeadline(time.Now().Add(cWebsocketWriteTimeout))
+    return h.connection.WriteJSON(msg)
+}
+
+func (h *WebsocketMessageTransport) FlushBatch(id uint64) {
+}
+
+func (h *WebsocketMessageTransport) BeginBatch(id uint64, msg IEventMessage) {
+}
+
+func (h *WebsocketMessageTransport) Close() {
+    h.closeOnce.Do(func() {
+        close(h.closed)
+    })
+
+    h.connection.Close()
+}
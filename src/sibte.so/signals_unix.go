@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// Signals main waits for, SIGHUP reloads the configuration and SIGUSR2 hands
+// the listeners off to a new process
+var pSignals = []os.Signal{syscall.SIGTERM, os.Interrupt, syscall.SIGHUP, syscall.SIGUSR2}
+
+func isReloadSignal(sig os.Signal) bool {
+    return sig == syscall.SIGHUP
+}
+
+func isRestartSignal(sig os.Signal) bool {
+    return sig == syscall.SIGUSR2
+}
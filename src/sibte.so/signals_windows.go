@@ -0,0 +1,18 @@
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// Windows has no SIGHUP or SIGUSR2, the configuration is reloaded through
+// /admin/reload and hot restart isn't supported
+var pSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+func isReloadSignal(sig os.Signal) bool {
+    return false
+}
+
+func isRestartSignal(sig os.Signal) bool {
+    return false
+}
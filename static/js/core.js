@@ -280,7 +280,13 @@ window.core = (function(win, doc, raspconfig) {
       _completeHandShake: function (msg) {
         if (!this.handshakeCompleted) {
           this.handshakeCompleted = true;
-          this.setNick(this.nick);
+          if (this.resumeToken) {
+            // Server restarted, get our nick and groups back
+            this.sock.send(JSON.stringify({'@': 'resume-session', msg: this.resumeToken}));
+            this.resumeToken = null;
+          } else {
+            this.setNick(this.nick);
+          }
           this.events.fire('handshake', SERVER_ALIAS);
           this.events.fire('message', {
             from: SERVER_ALIAS,
@@ -327,8 +333,17 @@ window.core = (function(win, doc, raspconfig) {
             this._on_rawmessage(msg.to, msg.pack_msg);
             break;
 
+          case 'server-going-away':
+            if (msg.reconnect && msg.resume_token) {
+              this.resumeToken = msg.resume_token;
+            }
+            break;
+
           case 'ping':
             this.sock.send(JSON.stringify({'@': 'pong', t: msg.t}));
+            if (msg.latency_ms) {
+              this.events.fire('latency', msg.latency_ms);
+            }
             break;
 
           default: